@@ -0,0 +1,252 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"iter"
+	"path"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/fumiama/go-docx"
+)
+
+// BatchOptions controls how RenderBatch and RenderArchive render records
+type BatchOptions struct {
+	// Render are the options each record is rendered with
+	Render RenderOptions
+	// Break separates the records rendered into one document by RenderBatch
+	Break MergeBreak
+	// Name is the file name of each record in the archive written by
+	// RenderArchive, an inline template executed with the record such as
+	// "invoice-{{ .Number }}.docx". The record number, counted from 1, is
+	// returned by {{ record }}. Records are named document-1.docx, document-2.docx
+	// and so on by default.
+	Name string
+}
+
+// Clone returns a copy of the template as it was read by New, with the same
+// functions and delimiters, rendered independently of t and of each other.
+// The clone shares the package of t and copies its parsed body, reading only
+// the relationships and media again. A template already rendered or merged
+// into is parsed again from its package.
+func (t *DocxTemplate) Clone() (*DocxTemplate, error) {
+	var c *DocxTemplate
+	var err error
+	if t.changed {
+		c, err = newTemplate(t.src)
+	} else {
+		c, err = t.copyTemplate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.copyFuncs(c)
+	c.delims = t.delims
+	return c, nil
+}
+
+// copyFuncs adds the functions registered with Funcs on t to c. The built-in
+// functions are bound to t and registered again for c.
+func (t *DocxTemplate) copyFuncs(c *DocxTemplate) {
+	builtin := t.styleFuncs()
+	for k, v := range t.funcs {
+		if _, ok := builtin[k]; !ok && k != "inject" {
+			c.funcs[k] = v
+		}
+	}
+}
+
+// copyTemplate returns a template sharing the package of t, with a copy of its
+// body added to a document read from the package without its body
+func (t *DocxTemplate) copyTemplate() (*DocxTemplate, error) {
+	shell, err := t.shell()
+	if err != nil {
+		return nil, err
+	}
+	doc, err := docx.Parse(bytes.NewReader(shell), int64(len(shell)))
+	if err != nil {
+		return nil, err
+	}
+	items, err := t.cloneBlock(t.doc.Document.Body.Items)
+	if err != nil {
+		return nil, err
+	}
+	rebind(doc, items)
+	document := t.doc.Document
+	document.Body = doc.Document.Body
+	document.Body.Items = items
+	doc.Document = document
+
+	return templateOf(doc, t.src, t.pkg, t.shell), nil
+}
+
+// rebind replaces the paragraphs of items, those of table cells included,
+// with copies made by doc, so that the images and links added to them are
+// stored in doc
+func rebind(doc *docx.Docx, items []interface{}) {
+	bind := func(p *docx.Paragraph) *docx.Paragraph {
+		np := doc.AddParagraph()
+		np.XMLName, np.Properties, np.Children = p.XMLName, p.Properties, p.Children
+		return np
+	}
+	for i, item := range items {
+		switch it := item.(type) {
+		case *docx.Paragraph:
+			items[i] = bind(it)
+		case *docx.Table:
+			for _, row := range it.TableRows {
+				for _, cell := range row.TableCells {
+					for j, p := range cell.Paragraphs {
+						cell.Paragraphs[j] = bind(p)
+					}
+					for _, tbl := range cell.Tables {
+						rebind(doc, []interface{}{tbl})
+					}
+				}
+			}
+		}
+	}
+}
+
+// emptyBody returns the package src with the body of document.xml left out
+func emptyBody(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	err := rewritePackage(src, &buf, map[string]func([]byte) ([]byte, error){
+		documentPart: func([]byte) ([]byte, error) {
+			return []byte(strings.Replace(xmlPart("w:document"), "></", "><w:body></w:body></", 1)), nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderBatch renders t once for each record and joins the results into one
+// document, each record separated from the previous by opts.Break. Records
+// are rendered under ctx, as RenderContext does.
+func RenderBatch[T any](ctx context.Context, t *DocxTemplate, records iter.Seq[T], opts BatchOptions) (*DocxTemplate, error) {
+	var docs []*DocxTemplate
+	var errs []error
+	for n, record := range numbered(records) {
+		doc, err := t.renderRecord(ctx, record, opts.Render)
+		if err != nil {
+			if !collected(err, opts.Render) {
+				return nil, fmt.Errorf("record %d: %w", n, err)
+			}
+			errs = append(errs, fmt.Errorf("record %d: %w", n, err))
+		}
+		docs = append(docs, doc)
+	}
+	if len(docs) == 0 {
+		return nil, errors.New("batch: no records")
+	}
+	out, err := MergeWithOptions(MergeOptions{Break: opts.Break}, docs...)
+	if err != nil {
+		return nil, err
+	}
+	return out, errors.Join(errs...)
+}
+
+// RenderArchive renders t once for each record and writes the results to w as
+// a zip archive, one document per record named by opts.Name. Each document is
+// written as soon as its record is rendered, under ctx as RenderContext does.
+func RenderArchive[T any](ctx context.Context, w io.Writer, t *DocxTemplate, records iter.Seq[T], opts BatchOptions) error {
+	pattern := opts.Name
+	if pattern == "" {
+		pattern = "document-" + t.delims.left + " record " + t.delims.right + ".docx"
+	}
+	zw := zip.NewWriter(w)
+	names := make(map[string]bool)
+	var errs []error
+	for n, record := range numbered(records) {
+		doc, err := t.renderRecord(ctx, record, opts.Render)
+		if err != nil {
+			if !collected(err, opts.Render) {
+				return fmt.Errorf("record %d: %w", n, err)
+			}
+			errs = append(errs, fmt.Errorf("record %d: %w", n, err))
+		}
+		name, err := t.recordName(pattern, record, n)
+		if err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+		if names[name] {
+			return fmt.Errorf("record %d: duplicate file name %q", n, name)
+		}
+		names[name] = true
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if err := doc.Save(fw); err != nil {
+			return fmt.Errorf("record %d: %w", n, err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return errors.Join(errs...)
+}
+
+// numbered yields the records with their numbers, counted from 1
+func numbered[T any](records iter.Seq[T]) iter.Seq2[int, interface{}] {
+	return func(yield func(int, interface{}) bool) {
+		n := 0
+		for record := range records {
+			n++
+			if !yield(n, record) {
+				return
+			}
+		}
+	}
+}
+
+// renderRecord renders a clone of t with record under ctx. With CollectErrors
+// the clone is returned along with the errors collected.
+func (t *DocxTemplate) renderRecord(ctx context.Context, record interface{}, opts RenderOptions) (*DocxTemplate, error) {
+	doc, err := t.Clone()
+	if err != nil {
+		return nil, err
+	}
+	return doc, doc.RenderContext(ctx, record, opts)
+}
+
+// collected reports whether err holds only the errors collected with
+// CollectErrors, which leave the document rendered
+func collected(err error, opts RenderOptions) bool {
+	var errs RenderErrors
+	return opts.CollectErrors && errors.As(err, &errs)
+}
+
+// recordName executes the name pattern with record number n
+func (t *DocxTemplate) recordName(pattern string, record interface{}, n int) (string, error) {
+	funcs := make(template.FuncMap)
+	for k, v := range t.funcs {
+		funcs[k] = v
+	}
+	funcs["record"] = func() int { return n }
+	tmpl, err := template.New("name").Delims(t.delims.left, t.delims.right).Funcs(funcs).Parse(pattern)
+	if err != nil {
+		return "", fmt.Errorf("name pattern: %w", err)
+	}
+	var b strings.Builder
+	if err := tmpl.Execute(&b, record); err != nil {
+		return "", fmt.Errorf("name pattern: %w", err)
+	}
+	name := strings.TrimSpace(b.String())
+	if name == "" {
+		return "", errors.New("name pattern: empty file name")
+	}
+	// Names leaving the directory the archive is extracted to are rejected
+	if strings.Contains(name, `\`) || name != path.Clean(name) || !filepath.IsLocal(name) {
+		return "", fmt.Errorf("name pattern: unsafe file name %q", name)
+	}
+	return name, nil
+}
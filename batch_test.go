@@ -0,0 +1,189 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+type testRecord struct {
+	ID   string
+	Name string
+}
+
+func TestRenderArchiveNames(t *testing.T) {
+	records := []testRecord{{"a1", "Ann"}, {"b2", "Bob"}}
+	tests := []struct {
+		name    string
+		pattern string
+		want    []string
+		// err is part of the error expected instead
+		err string
+	}{
+		{"default", "", []string{"document-1.docx", "document-2.docx"}, ""},
+		{"record", "report-{{ .ID }}-{{ record }}.docx", []string{"report-a1-1.docx", "report-b2-2.docx"}, ""},
+		{"directory", "{{ .Name }}/report.docx", []string{"Ann/report.docx", "Bob/report.docx"}, ""},
+		{"funcs", "{{ upper .Name }}.docx", []string{"ANN.docx", "BOB.docx"}, ""},
+		{"duplicate", "report.docx", nil, "duplicate file name"},
+		{"empty", "{{ if false }}x{{ end }}", nil, "empty file name"},
+		{"parent", "../{{ .ID }}.docx", nil, "unsafe file name"},
+		{"inner parent", "a/../../{{ .ID }}.docx", nil, "unsafe file name"},
+		{"absolute", "/tmp/{{ .ID }}.docx", nil, "unsafe file name"},
+		{"unclean", "a//{{ .ID }}.docx", nil, "unsafe file name"},
+		{"backslash", `..\{{ .ID }}.docx`, nil, "unsafe file name"},
+		{"bad pattern", "{{ .ID", nil, "name pattern"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, "Hello {{ .Name }}")
+			tpl.Funcs(map[string]interface{}{"upper": strings.ToUpper})
+			var buf bytes.Buffer
+			err := RenderArchive(context.Background(), &buf, tpl, slices.Values(records), BatchOptions{Name: tt.pattern})
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for i, f := range zr.File {
+				names = append(names, f.Name)
+				rc, err := f.Open()
+				if err != nil {
+					t.Fatal(err)
+				}
+				data, err := io.ReadAll(rc)
+				rc.Close()
+				if err != nil {
+					t.Fatal(err)
+				}
+				doc, err := New(bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatal(err)
+				}
+				if got, want := texts(doc), []string{"Hello " + records[i].Name}; !slices.Equal(got, want) {
+					t.Errorf("%s texts = %q, want %q", f.Name, got, want)
+				}
+			}
+			if !slices.Equal(names, tt.want) {
+				t.Errorf("names = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderBatch(t *testing.T) {
+	tpl := textTemplate(t, "Hello {{ .Name }}")
+	records := []testRecord{{"a1", "Ann"}, {"b2", "Bob"}}
+	out, err := RenderBatch(context.Background(), tpl, slices.Values(records), BatchOptions{Break: PageBreak})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := texts(out), []string{"Hello Ann", "", "Hello Bob"}; !slices.Equal(got, want) {
+		t.Errorf("texts = %q, want %q", got, want)
+	}
+	// The template is left unrendered
+	if got, want := texts(tpl), []string{"Hello {{ .Name }}"}; !slices.Equal(got, want) {
+		t.Errorf("template texts = %q, want %q", got, want)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := RenderBatch(ctx, tpl, slices.Values(records), BatchOptions{}); !errors.Is(err, context.Canceled) {
+		t.Errorf("err = %v, want context.Canceled", err)
+	}
+}
+
+// drawingInjector adds the image data to the paragraph of its inject action
+type drawingInjector []byte
+
+func (d drawingInjector) Inject(_ *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
+	_, err := p.AddInlineDrawing(d)
+	return nil, err
+}
+
+func TestClone(t *testing.T) {
+	img, err := os.ReadFile("testdata/test_image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		// render renders the template before it is cloned
+		render bool
+	}{
+		{"parsed", false},
+		{"rendered", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, "[[ upper .Name ]]", "[[ inject .Image ]]")
+			tpl.Funcs(map[string]interface{}{"upper": strings.ToUpper})
+			tpl.Delims("[[", "]]")
+			if tt.render {
+				if err := tpl.Render(map[string]interface{}{"Name": "tpl", "Image": drawingInjector(img)}); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			names := []string{"ann", "bob", "cy", "di"}
+			clones := make([]*DocxTemplate, len(names))
+			errs := make([]error, len(names))
+			var wg sync.WaitGroup
+			for i, name := range names {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					c, err := tpl.Clone()
+					if err == nil {
+						err = c.Render(map[string]interface{}{"Name": name, "Image": drawingInjector(img)})
+					}
+					clones[i], errs[i] = c, err
+				}()
+			}
+			wg.Wait()
+			for i, c := range clones {
+				if errs[i] != nil {
+					t.Fatal(errs[i])
+				}
+				if got, want := texts(c)[0], strings.ToUpper(names[i]); got != want {
+					t.Errorf("clone %d text = %q, want %q", i, got, want)
+				}
+				// The image is stored in the clone
+				var rids []string
+				drawings(c.doc.Document.Body.Items, func(rid string) { rids = append(rids, rid) })
+				if len(rids) != 1 {
+					t.Fatalf("clone %d holds %d images, want 1", i, len(rids))
+				}
+				target, err := c.doc.ReferTarget(rids[0])
+				if err != nil || c.doc.Media(strings.TrimPrefix(target, "media/")) == nil {
+					t.Errorf("clone %d lacks the media of %s: %v", i, rids[0], err)
+				}
+				if !strings.Contains(savedPart(t, c, documentPart), "<pic:pic") {
+					t.Errorf("clone %d saved without its image", i)
+				}
+			}
+			if !tt.render {
+				if got, want := texts(tpl), []string{"[[ upper .Name ]]", "[[ inject .Image ]]"}; !slices.Equal(got, want) {
+					t.Errorf("template texts = %q, want %q", got, want)
+				}
+			}
+		})
+	}
+}
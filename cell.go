@@ -0,0 +1,274 @@
+package docxexp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"regexp"
+
+	"github.com/fumiama/go-docx"
+)
+
+// go-docx reads the paragraphs and nested tables of a cell into separate
+// slices and writes the paragraphs first. To keep the order of a cell, each
+// nested table has a slot paragraph standing at its place in Paragraphs: the
+// nth slot of a cell holds the nth table of its Tables.
+
+// tableSlot is the only child of a slot paragraph
+type tableSlot struct{}
+
+// MarshalXML implements xml.Marshaler. Slots are replaced by their tables
+// before the document is written, so a slot writes nothing.
+func (*tableSlot) MarshalXML(*xml.Encoder, xml.StartElement) error {
+	return nil
+}
+
+func slotParagraph() *docx.Paragraph {
+	return &docx.Paragraph{Children: []interface{}{&tableSlot{}}}
+}
+
+func isSlot(p *docx.Paragraph) bool {
+	if len(p.Children) != 1 {
+		return false
+	}
+	_, ok := p.Children[0].(*tableSlot)
+	return ok
+}
+
+// cellItems returns the paragraphs and tables of cell in their order. Tables
+// without a slot come last.
+func cellItems(cell *docx.WTableCell) []interface{} {
+	items := make([]interface{}, 0, len(cell.Paragraphs)+len(cell.Tables))
+	k := 0
+	for _, p := range cell.Paragraphs {
+		if !isSlot(p) {
+			items = append(items, p)
+		} else if k < len(cell.Tables) {
+			items = append(items, cell.Tables[k])
+			k++
+		}
+	}
+	for _, tbl := range cell.Tables[k:] {
+		items = append(items, tbl)
+	}
+	return items
+}
+
+// setCellItems makes the paragraphs and tables of items the content of cell.
+// A cell must end with a paragraph, so an empty one follows a last table.
+func setCellItems(cell *docx.WTableCell, items []interface{}) {
+	cell.Paragraphs = nil
+	cell.Tables = nil
+	for _, item := range items {
+		switch it := item.(type) {
+		case *docx.Paragraph:
+			cell.Paragraphs = append(cell.Paragraphs, it)
+		case *docx.Table:
+			cell.Paragraphs = append(cell.Paragraphs, slotParagraph())
+			cell.Tables = append(cell.Tables, it)
+		}
+	}
+	if n := len(cell.Paragraphs); n == 0 || isSlot(cell.Paragraphs[n-1]) {
+		cell.Paragraphs = append(cell.Paragraphs, &docx.Paragraph{})
+	}
+}
+
+// eachCell calls fn for the cells of the tables among items and of the
+// tables nested in them, each cell before its own tables
+func eachCell(items []interface{}, fn func(*docx.WTableCell)) {
+	for _, item := range items {
+		tbl, ok := item.(*docx.Table)
+		if !ok {
+			continue
+		}
+		for _, row := range tbl.TableRows {
+			for _, cell := range row.TableCells {
+				fn(cell)
+				for _, nested := range cell.Tables {
+					eachCell([]interface{}{nested}, fn)
+				}
+			}
+		}
+	}
+}
+
+// orderCells gives the cells of doc holding tables the order their
+// paragraphs and tables have in document.xml, data
+func orderCells(doc *docx.Docx, data []byte) error {
+	nested := false
+	eachCell(doc.Document.Body.Items, func(cell *docx.WTableCell) {
+		nested = nested || len(cell.Tables) > 0
+	})
+	if !nested {
+		return nil
+	}
+	orders, err := cellOrders(data)
+	if err != nil {
+		return err
+	}
+	i := 0
+	eachCell(doc.Document.Body.Items, func(cell *docx.WTableCell) {
+		var order []bool
+		if i < len(orders) {
+			order = orders[i]
+		}
+		i++
+		if len(cell.Tables) == 0 {
+			return
+		}
+		var items []interface{}
+		ps, ts := cell.Paragraphs, cell.Tables
+		for _, isTable := range order {
+			if isTable && len(ts) > 0 {
+				items = append(items, ts[0])
+				ts = ts[1:]
+			} else if !isTable && len(ps) > 0 {
+				items = append(items, ps[0])
+				ps = ps[1:]
+			}
+		}
+		if len(ps) > 0 || len(ts) > 0 {
+			// The cell was not read as expected: keep the order of go-docx
+			items = cellItems(cell)
+		}
+		setCellItems(cell, items)
+	})
+	return nil
+}
+
+// cellOrders returns, for each table cell of document.xml in document order,
+// whether each of its paragraphs and tables is a table. Only the elements
+// go-docx reads are walked: tables of the body and of cells.
+func cellOrders(data []byte) ([][]bool, error) {
+	var orders [][]bool
+	// stack holds the names of the open elements, "" for those go-docx
+	// skips, and cells the indexes in orders of the open cells
+	stack := []string{""}
+	var cells []int
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return orders, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			parent, name := stack[len(stack)-1], tok.Name.Local
+			read := false
+			switch name {
+			case "document":
+				read = len(stack) == 1
+			case "body":
+				read = parent == "document"
+			case "tbl":
+				read = parent == "body" || parent == "tc"
+			case "tr":
+				read = parent == "tbl"
+			case "tc":
+				read = parent == "tr"
+			case "p":
+				read = parent == "tc"
+			}
+			if !read {
+				stack = append(stack, "")
+				continue
+			}
+			if parent == "tc" {
+				c := cells[len(cells)-1]
+				orders[c] = append(orders[c], name == "tbl")
+			}
+			if name == "tc" {
+				cells = append(cells, len(orders))
+				orders = append(orders, nil)
+			}
+			stack = append(stack, name)
+		case xml.EndElement:
+			if stack[len(stack)-1] == "tc" {
+				cells = cells[:len(cells)-1]
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+}
+
+// placedTable is written in place of a slot on Save: a comment holding the
+// table, which restoreCellTables turns back into the table
+type placedTable struct {
+	tbl *docx.Table
+}
+
+// MarshalXML implements xml.Marshaler
+func (p *placedTable) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	data, err := xml.Marshal(p.tbl)
+	if err != nil {
+		return err
+	}
+	return e.EncodeToken(xml.Comment(" docxexp:tbl:" + base64.StdEncoding.EncodeToString(data) + " "))
+}
+
+// placeCellTables puts the nested tables of the cells among items at their
+// place for go-docx to write and returns the function restoring the cells
+func placeCellTables(items []interface{}) (restore func()) {
+	type saved struct {
+		cell       *docx.WTableCell
+		paragraphs []*docx.Paragraph
+		tables     []*docx.Table
+	}
+	var cells []saved
+	eachCell(items, func(cell *docx.WTableCell) {
+		if len(cell.Tables) == 0 {
+			return
+		}
+		cells = append(cells, saved{cell, cell.Paragraphs, cell.Tables})
+	})
+	for _, s := range cells {
+		var paragraphs []*docx.Paragraph
+		last := false
+		for _, item := range cellItems(s.cell) {
+			switch it := item.(type) {
+			case *docx.Paragraph:
+				paragraphs = append(paragraphs, it)
+				last = false
+			case *docx.Table:
+				paragraphs = append(paragraphs, &docx.Paragraph{Children: []interface{}{&placedTable{it}}})
+				last = true
+			}
+		}
+		if last {
+			paragraphs = append(paragraphs, &docx.Paragraph{})
+		}
+		s.cell.Paragraphs = paragraphs
+		s.cell.Tables = nil
+	}
+	return func() {
+		for _, s := range cells {
+			s.cell.Paragraphs = s.paragraphs
+			s.cell.Tables = s.tables
+		}
+	}
+}
+
+var placedTableRe = regexp.MustCompile(`<w:p><!-- docxexp:tbl:([A-Za-z0-9+/=]*) --></w:p>`)
+
+// restoreCellTables replaces the placed table comments in document.xml with
+// the tables they hold
+func restoreCellTables(data []byte) ([]byte, error) {
+	var err error
+	data = placedTableRe.ReplaceAllFunc(data, func(m []byte) []byte {
+		tbl, decodeErr := base64.StdEncoding.DecodeString(string(placedTableRe.FindSubmatch(m)[1]))
+		if decodeErr != nil {
+			err = decodeErr
+			return m
+		}
+		tbl, decodeErr = restoreCellTables(tbl)
+		if decodeErr != nil {
+			err = decodeErr
+		}
+		return tbl
+	})
+	return data, err
+}
@@ -0,0 +1,132 @@
+package docxexp
+
+import (
+	"bytes"
+	"regexp"
+	"slices"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// bodyTemplate returns a template whose document.xml has the body XML body
+func bodyTemplate(t *testing.T, body string) *DocxTemplate {
+	t.Helper()
+	var buf bytes.Buffer
+	err := rewritePackage(packageOf(t, func(*docx.Docx) {}), &buf, map[string]func([]byte) ([]byte, error){
+		documentPart: func([]byte) ([]byte, error) {
+			return []byte(`<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` +
+				`<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:body>` +
+				body + `</w:body></w:document>`), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tpl
+}
+
+func cellXML(items ...string) string {
+	s := "<w:tbl><w:tr><w:tc>"
+	for _, item := range items {
+		if item == "" || item[0] != '<' {
+			item = "<w:p><w:r><w:t>" + item + "</w:t></w:r></w:p>"
+		}
+		s += item
+	}
+	return s + "</w:tc></w:tr></w:tbl>"
+}
+
+var textRe = regexp.MustCompile(`<w:t[ >][^<]*`)
+
+func TestCellOrder(t *testing.T) {
+	cases := []struct {
+		name   string
+		tpl    func(t *testing.T) *DocxTemplate
+		data   interface{}
+		texts  []string
+		orders [][]bool
+	}{
+		{
+			name: "injected",
+			tpl: func(t *testing.T) *DocxTemplate {
+				return newTestTemplate(t, func(doc *docx.Docx) {
+					cell := doc.AddTable(1, 1, 0, nil).TableRows[0].TableCells[0]
+					cell.AddParagraph().AddText("before")
+					cell.AddParagraph().AddText("{{ inject .T }}")
+					cell.AddParagraph().AddText("after")
+				})
+			},
+			data:   map[string]interface{}{"T": TableInjector{Rows: [][]string{{"x"}}}},
+			texts:  []string{"before", "x", "after"},
+			orders: [][]bool{{false, true, false}, {false}},
+		},
+		{
+			name: "injected last",
+			tpl: func(t *testing.T) *DocxTemplate {
+				return newTestTemplate(t, func(doc *docx.Docx) {
+					doc.AddTable(1, 1, 0, nil).TableRows[0].TableCells[0].AddParagraph().AddText("{{ inject .T }}")
+				})
+			},
+			data:   map[string]interface{}{"T": TableInjector{Rows: [][]string{{"x"}}}},
+			texts:  []string{"x"},
+			orders: [][]bool{{true, false}, {false}},
+		},
+		{
+			name: "kept",
+			tpl: func(t *testing.T) *DocxTemplate {
+				return bodyTemplate(t, cellXML("before", cellXML("{{ .X }}"), "after"))
+			},
+			data:   map[string]interface{}{"X": "x"},
+			texts:  []string{"before", "x", "after"},
+			orders: [][]bool{{false, true, false}, {false}},
+		},
+		{
+			name: "looped",
+			tpl: func(t *testing.T) *DocxTemplate {
+				return bodyTemplate(t, cellXML("{{for r in Rows}}", cellXML("{{ r }}"), "{{endfor}}", "end"))
+			},
+			data:   map[string]interface{}{"Rows": []string{"a", "b"}},
+			texts:  []string{"a", "b", "end"},
+			orders: [][]bool{{true, true, false}, {false}, {false}},
+		},
+		{
+			name: "looped last",
+			tpl: func(t *testing.T) *DocxTemplate {
+				return bodyTemplate(t, cellXML("{{for r in Rows}}", cellXML("{{ r }}"), "{{endfor}}"))
+			},
+			data:   map[string]interface{}{"Rows": []string{"a", "b"}},
+			texts:  []string{"a", "b"},
+			orders: [][]bool{{true, true, false}, {false}, {false}},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			tpl := c.tpl(t)
+			if err := tpl.Render(c.data); err != nil {
+				t.Fatal(err)
+			}
+			document := savedPart(t, tpl, documentPart)
+			var got []string
+			for _, m := range textRe.FindAllString(document, -1) {
+				if text := m[bytes.IndexByte([]byte(m), '>')+1:]; text != "" {
+					got = append(got, text)
+				}
+			}
+			if !slices.Equal(got, c.texts) {
+				t.Errorf("texts = %q, want %q", got, c.texts)
+			}
+			orders, err := cellOrders([]byte(document))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !slices.EqualFunc(orders, c.orders, slices.Equal) {
+				t.Errorf("cell orders = %v, want %v", orders, c.orders)
+			}
+		})
+	}
+}
@@ -3,11 +3,16 @@ package docxexp
 import (
 	"archive/zip"
 	"bytes"
+	"context"
+	"crypto/sha256"
 	"encoding/xml"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"reflect"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/fumiama/go-docx"
@@ -23,27 +28,155 @@ type DocxTemplate struct {
 	doc   *docx.Docx
 	funcs template.FuncMap
 
-	// injectors maps placeholder strings to Injector objects
-	injectors map[string]Injector
+	// injectors maps placeholder strings to injectors
+	injectors map[string]InjectorV2
+	// images are the runs of the images added by InjectContext.Image, by the
+	// hash of their data
+	images map[[sha256.Size]byte]*docx.Run
+
+	// styles maps placeholder strings to pending formatting changes. Both
+	// styles and injectors only hold the placeholders of the paragraph being
+	// rendered, numbered by marks.
+	styles map[string]styleOp
+	marks  int
+
+	// cell is the table cell currently being rendered, if any
+	cell *docx.WTableCell
+	// captions are the paragraphs marked with {{caption}}
+	captions map[*docx.Paragraph]bool
+
+	// opts are the options of the render in progress
+	opts RenderOptions
+
+	// loc is the location being rendered and loops the loop iterations
+	// in progress, both reported by RenderError
+	loc   Location
+	loops []LoopFrame
+	// base is the template index of the first item passed to traverseItems
+	base int
+	// rowRef identifies the template row passed to processRow
+	rowRef CellRef
+	// errs are the errors collected with RenderOptions.CollectErrors
+	errs RenderErrors
+
+	// delims are the tag delimiters, see Delims and BlockDelims
+	delims *delims
+
+	// vars are the bindings made by {{set}}, one frame per scope
+	vars []map[string]interface{}
+
+	// macros are the blocks defined by {{define}} and calls the depth of
+	// {{call}} being rendered
+	macros map[string]macro
+	calls  int
+
+	// src and pkg are the package the template was read from and merged the
+	// definitions copied into it by {{include}}
+	src    []byte
+	pkg    *zip.Reader
+	merged merged
+	// shell returns src with an empty body, read by Clone, and changed
+	// reports that doc no longer holds the body read from src
+	shell   func() ([]byte, error)
+	changed bool
+	// includes are the templates being included, outermost first
+	includes []string
+
+	// ctx and usage are the context and resource usage of the render in
+	// progress, see RenderContext
+	ctx   context.Context
+	usage *usage
+}
+
+// RenderOptions controls how a template is rendered
+type RenderOptions struct {
+	// RemoveEmptyTables removes a table when all of its row loops are empty.
+	// Tables left without any row are always removed.
+	RemoveEmptyTables bool
+	// RemoveEmptyTableCaption also removes the caption of a removed table:
+	// the paragraph right before it, when it has the Caption style or holds
+	// a {{caption}} directive
+	RemoveEmptyTableCaption bool
+	// EmptyTableText replaces a removed table with a paragraph holding this text
+	EmptyTableText string
+
+	// MissingKey selects how values missing from the data are handled in
+	// block tags, row tags and inline actions
+	MissingKey MissingKey
+	// OnMissingKey returns the value used for expr with MissingKeyFunc
+	OnMissingKey func(expr string, loc Location) (interface{}, error)
+
+	// RemoveEmptyParagraphs removes paragraphs holding template tags that
+	// render to empty text. Paragraphs left blank in the template are kept.
+	RemoveEmptyParagraphs bool
+
+	// CollectErrors keeps rendering past errors. Broken tags are marked in
+	// the document and all errors are returned as RenderErrors.
+	CollectErrors bool
+
+	// IncludeFS opens the templates named by {{include}}. Paths are opened
+	// from the working directory when it is nil.
+	IncludeFS fs.FS
+
+	// FieldTags matches struct fields by their docx tag, or their json tag
+	// when they have none, before their Go name. Fields tagged "-" are hidden.
+	FieldTags bool
+	// IgnoreCase matches struct fields and map keys case-insensitively when
+	// no name matches exactly
+	IgnoreCase bool
+	// OmitEmpty makes the empty values of fields tagged omitempty print as
+	// nothing, along with the fields reached through them, and conditions
+	// on them false
+	OmitEmpty bool
+
+	// Limits caps the resources used by the render
+	Limits Limits
 }
 
 // New creates a new DocxTemplate
 func New(r io.ReaderAt, size int64) (*DocxTemplate, error) {
 	// Pre-process to ensure Content_Types includes image formats
-	r, size, err := ensureContentTypes(r, size)
+	src, err := ensureContentTypes(r, size)
 	if err != nil {
 		return nil, err
 	}
+	return newTemplate(src)
+}
 
+// newTemplate parses the package src, whose content types are already set
+func newTemplate(src []byte) (*DocxTemplate, error) {
+	r, size := bytes.NewReader(src), int64(len(src))
 	doc, err := docx.Parse(r, size)
 	if err != nil {
 		return nil, err
 	}
+	pkg, err := zip.NewReader(r, size)
+	if err != nil {
+		return nil, err
+	}
+	document, err := readPart(pkg, documentPart)
+	if err != nil {
+		return nil, err
+	}
+	if err := orderCells(doc, document); err != nil {
+		return nil, err
+	}
+	return templateOf(doc, src, pkg, sync.OnceValues(func() ([]byte, error) { return emptyBody(src) })), nil
+}
+
+// templateOf returns a template rendering doc, read from the package src
+func templateOf(doc *docx.Docx, src []byte, pkg *zip.Reader, shell func() ([]byte, error)) *DocxTemplate {
 	return &DocxTemplate{
 		doc:       doc,
+		src:       src,
+		pkg:       pkg,
+		shell:     shell,
 		funcs:     make(template.FuncMap),
-		injectors: make(map[string]Injector),
-	}, nil
+		injectors: make(map[string]InjectorV2),
+		styles:    make(map[string]styleOp),
+		captions:  make(map[*docx.Paragraph]bool),
+		delims:    defaultDelims,
+	}
 }
 
 type types struct {
@@ -59,12 +192,12 @@ type types struct {
 	} `xml:"Override"`
 }
 
-func ensureContentTypes(r io.ReaderAt, size int64) (io.ReaderAt, int64, error) {
+func ensureContentTypes(r io.ReaderAt, size int64) ([]byte, error) {
 	buf := new(bytes.Buffer)
 	w := zip.NewWriter(buf)
 	zr, err := zip.NewReader(r, size)
 	if err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
 	var ctFound bool
@@ -73,17 +206,17 @@ func ensureContentTypes(r io.ReaderAt, size int64) (io.ReaderAt, int64, error) {
 			ctFound = true
 			rc, err := f.Open()
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 			data, err := io.ReadAll(rc)
 			rc.Close()
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 
 			var t types
 			if err := xml.Unmarshal(data, &t); err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 			t.Xmlns = "http://schemas.openxmlformats.org/package/2006/content-types"
 
@@ -123,43 +256,42 @@ func ensureContentTypes(r io.ReaderAt, size int64) (io.ReaderAt, int64, error) {
 
 			newData, err := xml.Marshal(t)
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 			// Add xml header
 			newData = append([]byte(xml.Header), newData...)
 
 			fw, err := w.Create(f.Name)
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 			if _, err := fw.Write(newData); err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 		} else {
 			fw, err := w.Create(f.Name)
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 			rc, err := f.Open()
 			if err != nil {
-				return nil, 0, err
+				return nil, err
 			}
 			if _, err := io.Copy(fw, rc); err != nil {
 				rc.Close()
-				return nil, 0, err
+				return nil, err
 			}
 			rc.Close()
 		}
 	}
 	if !ctFound {
-		return nil, 0, fmt.Errorf("[Content_Types].xml not found")
+		return nil, fmt.Errorf("[Content_Types].xml not found")
 	}
 	if err := w.Close(); err != nil {
-		return nil, 0, err
+		return nil, err
 	}
 
-	b := buf.Bytes()
-	return bytes.NewReader(b), int64(len(b)), nil
+	return buf.Bytes(), nil
 }
 
 // Funcs registers custom functions
@@ -171,40 +303,181 @@ func (t *DocxTemplate) Funcs(f template.FuncMap) {
 
 // Save writes the document to w
 func (t *DocxTemplate) Save(w io.Writer) error {
-	_, err := t.doc.WriteTo(w)
-	return err
+	buf := new(bytes.Buffer)
+	restore := placeCellTables(t.doc.Document.Body.Items)
+	_, err := t.doc.WriteTo(buf)
+	restore()
+	if err != nil {
+		return err
+	}
+	patches, err := t.patches(buf.Bytes())
+	if err != nil {
+		return err
+	}
+	return rewritePackage(buf.Bytes(), w, patches)
 }
 
-func (t *DocxTemplate) Render(data interface{}) error {
-	newItems, err := t.traverseItems(t.doc.Document.Body.Items, data)
+// patches returns the patches applied to the parts of the package data
+// written by go-docx on Save
+func (t *DocxTemplate) patches(data []byte) (map[string]func([]byte) ([]byte, error), error) {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
 	if err != nil {
-		return err
+		return nil, err
 	}
-	t.doc.Document.Body.Items = newItems
-	return nil
+	rels, err := readPart(zr, relsPart)
+	if err != nil {
+		return nil, err
+	}
+	patches := t.merged.patches(zr, maxID(rels, relIDRe)+1)
+	sections := patches[documentPart]
+	patches[documentPart] = func(part []byte) ([]byte, error) {
+		part, err := restoreCellTables(part)
+		if err == nil {
+			part, err = applyLayoutMarkers(part)
+		}
+		if err != nil || sections == nil {
+			return part, err
+		}
+		return sections(part)
+	}
+	return patches, nil
+}
+
+// Render renders the template with data using the default options
+func (t *DocxTemplate) Render(data interface{}) error {
+	return t.RenderWithOptions(data, RenderOptions{})
+}
+
+// RenderWithOptions renders the template with data
+func (t *DocxTemplate) RenderWithOptions(data interface{}, opts RenderOptions) error {
+	return t.RenderContext(context.Background(), data, opts)
 }
 
 func (t *DocxTemplate) traverseItems(items []interface{}, data interface{}) ([]interface{}, error) {
+	if err := t.enter(); err != nil {
+		return nil, err
+	}
 	var newItems []interface{}
+	base := t.base
+	t.vars = append(t.vars, make(map[string]interface{}))
+	defer func() {
+		t.base = base
+		t.vars = t.vars[:len(t.vars)-1]
+		t.leave()
+	}()
 	i := 0
 	for i < len(items) {
+		if err := t.checkContext(); err != nil {
+			return nil, err
+		}
 		item := items[i]
+		t.locate(base+i, item)
 
 		// Check for block start in Paragraph
 		if p, ok := item.(*docx.Paragraph); ok {
 			text := t.getParagraphText(p)
+			// A paragraph holding only comments is removed
+			if t.delims.commentOnly(text) {
+				i++
+				continue
+			}
+			// {{define name}} blocks are registered and removed
+			if name, isDefine := t.parseDefineTag(text); isDefine {
+				endIndex, err := t.findDefineEnd(items, i+1)
+				if err != nil {
+					if t.collect(err, text, p) {
+						newItems = append(newItems, p)
+						i++
+						continue
+					}
+					return nil, t.newError(err, text)
+				}
+				t.macros[name] = macro{items: items[i+1 : endIndex], base: base + i + 1}
+				i = endIndex + 1
+				continue
+			}
+			// {{call name expr}} renders a defined block
+			if name, expr, isCall := t.parseCallTag(text); isCall && t.hasMacro(name) {
+				callItems, err := t.callMacro(name, expr, data)
+				if errors.Is(err, errVerbatim) {
+					newItems = append(newItems, p)
+					i++
+					continue
+				}
+				if err != nil {
+					if t.collect(err, text, p) {
+						newItems = append(newItems, p)
+						i++
+						continue
+					}
+					return nil, t.newError(err, text)
+				}
+				newItems = append(newItems, callItems...)
+				i++
+				continue
+			}
+			// {{include "path.docx"}} renders another template in place
+			if expr, isInclude := t.parseIncludeTag(text); isInclude {
+				includeItems, err := t.include(expr, data)
+				if errors.Is(err, errVerbatim) {
+					newItems = append(newItems, p)
+					i++
+					continue
+				}
+				if err != nil {
+					if t.collect(err, text, p) {
+						newItems = append(newItems, p)
+						i++
+						continue
+					}
+					return nil, t.newError(err, text)
+				}
+				newItems = append(newItems, includeItems...)
+				i++
+				continue
+			}
+			// {{set name = expr}} binds name for the rest of the scope
+			if name, expr, isSet := t.parseSetTag(text); isSet {
+				val, err := t.evalPipeline(expr, data)
+				if err != nil {
+					if !t.collect(err, expr, p) {
+						return nil, t.newError(err, expr)
+					}
+					newItems = append(newItems, p)
+				}
+				t.setVar(name, val)
+				i++
+				continue
+			}
 			// Check for {{for ...}}
 			if variable, sliceExpr, isFor := t.parseForTag(text); isFor {
 				// Find end tag
 				endIndex, err := t.findBlockEnd(items, i+1, "endfor")
 				if err != nil {
-					return nil, err
+					if t.collect(err, text, p) {
+						newItems = append(newItems, p)
+						i++
+						continue
+					}
+					return nil, t.newError(err, text)
 				}
 
 				// Execute Loop
+				t.base = base + i + 1
 				loopItems, err := t.executeLoop(items[i+1:endIndex], variable, sliceExpr, data)
+				t.base = base
+				if errors.Is(err, errVerbatim) {
+					newItems = append(newItems, items[i:endIndex+1]...)
+					i = endIndex + 1
+					continue
+				}
 				if err != nil {
-					return nil, err
+					if t.collect(err, sliceExpr, p) {
+						newItems = append(newItems, items[i:endIndex+1]...)
+						i = endIndex + 1
+						continue
+					}
+					return nil, t.newError(err, sliceExpr)
 				}
 				newItems = append(newItems, loopItems...)
 
@@ -216,13 +489,30 @@ func (t *DocxTemplate) traverseItems(items []interface{}, data interface{}) ([]i
 			if condExpr, isIf := t.parseIfTag(text); isIf {
 				endIndex, err := t.findBlockEnd(items, i+1, "endif")
 				if err != nil {
-					return nil, err
+					if t.collect(err, text, p) {
+						newItems = append(newItems, p)
+						i++
+						continue
+					}
+					return nil, t.newError(err, text)
 				}
 
 				// Execute If
+				t.base = base + i + 1
 				ifResult, err := t.executeIf(items[i+1:endIndex], condExpr, data)
+				t.base = base
+				if errors.Is(err, errVerbatim) {
+					newItems = append(newItems, items[i:endIndex+1]...)
+					i = endIndex + 1
+					continue
+				}
 				if err != nil {
-					return nil, err
+					if t.collect(err, condExpr, p) {
+						newItems = append(newItems, items[i:endIndex+1]...)
+						i = endIndex + 1
+						continue
+					}
+					return nil, t.newError(err, condExpr)
 				}
 				newItems = append(newItems, ifResult...)
 
@@ -236,18 +526,37 @@ func (t *DocxTemplate) traverseItems(items []interface{}, data interface{}) ([]i
 		case *docx.Paragraph:
 			replacedItems, err := t.processParagraph(it, data)
 			if err != nil {
-				return nil, err
+				if !t.collect(err, "", it) {
+					return nil, t.newError(err, "")
+				}
+				replacedItems = nil
 			}
-			if replacedItems != nil {
-				newItems = append(newItems, replacedItems...)
-			} else {
-				newItems = append(newItems, it)
+			if replacedItems == nil {
+				replacedItems = []interface{}{it}
+			}
+			if err := t.countParagraphs(replacedItems...); err != nil {
+				return nil, t.newError(err, "")
 			}
+			newItems = append(newItems, replacedItems...)
 		case *docx.Table:
-			if err := t.processTable(it, data); err != nil {
-				return nil, err
+			keep, err := t.processTable(it, data)
+			if err != nil {
+				return nil, t.newError(err, "")
+			}
+			if keep {
+				newItems = append(newItems, it)
+				break
+			}
+			if t.opts.RemoveEmptyTableCaption && len(newItems) > 0 {
+				if p, ok := newItems[len(newItems)-1].(*docx.Paragraph); ok && t.isCaption(p) {
+					newItems = newItems[:len(newItems)-1]
+				}
+			}
+			if t.opts.EmptyTableText != "" {
+				p := createParagraph(t.doc)
+				p.AddText(t.opts.EmptyTableText)
+				newItems = append(newItems, p)
 			}
-			newItems = append(newItems, it)
 		default:
 			newItems = append(newItems, it)
 		}
@@ -256,12 +565,23 @@ func (t *DocxTemplate) traverseItems(items []interface{}, data interface{}) ([]i
 	return newItems, nil
 }
 
+// locate records item, found at index of its body or cell, as the location
+// being rendered
+func (t *DocxTemplate) locate(index int, item interface{}) {
+	if len(t.loc.Cells) == 0 {
+		t.loc.Item = index
+	}
+	t.loc.Paragraph = index
+	t.loc.Text = ""
+	if p, ok := item.(*docx.Paragraph); ok {
+		t.loc.Text = t.getParagraphText(p)
+	}
+}
+
 func (t *DocxTemplate) parseForTag(text string) (string, string, bool) {
 	// {{for var in slice}}
-	text = strings.TrimSpace(text)
-	if strings.HasPrefix(text, "{{for ") && strings.HasSuffix(text, "}}") {
-		content := strings.TrimSuffix(strings.TrimPrefix(text, "{{for "), "}}")
-		parts := strings.Split(content, " in ")
+	if content, ok := t.delims.blockTag(text); ok && strings.HasPrefix(content, "for ") {
+		parts := strings.Split(strings.TrimPrefix(content, "for "), " in ")
 		if len(parts) == 2 {
 			return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), true
 		}
@@ -271,45 +591,38 @@ func (t *DocxTemplate) parseForTag(text string) (string, string, bool) {
 
 func (t *DocxTemplate) parseIfTag(text string) (string, bool) {
 	// {{if cond}}
-	text = strings.TrimSpace(text)
-	if strings.HasPrefix(text, "{{if ") && strings.HasSuffix(text, "}}") {
-		return strings.TrimSuffix(strings.TrimPrefix(text, "{{if "), "}}"), true
+	if content, ok := t.delims.blockTag(text); ok && strings.HasPrefix(content, "if ") {
+		return strings.TrimPrefix(content, "if "), true
 	}
 	return "", false
 }
 
+// findBlockEnd returns the index of the endTag paragraph closing the block
+// that starts before items[start]. Blocks of the same kind nested inside it
+// are skipped; blocks of the other kind hold their own end tags.
 func (t *DocxTemplate) findBlockEnd(items []interface{}, start int, endTag string) (int, error) {
+	keyword := strings.TrimPrefix(endTag, "end")
 	depth := 0
 	for i := start; i < len(items); i++ {
-		if p, ok := items[i].(*docx.Paragraph); ok {
-			text := strings.TrimSpace(t.getParagraphText(p))
-			if strings.HasPrefix(text, "{{for ") || strings.HasPrefix(text, "{{if ") {
-				depth++
-			} else if text == "{{"+endTag+"}}" {
-				if depth == 0 {
-					return i, nil
-				}
-				depth--
-			} else if (endTag == "endfor" && text == "{{endfor}}") || (endTag == "endif" && text == "{{endif}}") {
-				// Handle exact match or simple match
-				if depth == 0 {
-					return i, nil
-				}
-				depth--
-			}
-			// Check for generic end if mixed? No, strictly match.
-			// Simplified check:
-			if strings.Contains(text, "{{"+endTag+"}}") {
-				// This is loose. Ideally strictly parse.
-				// For now assume the tag is the whole paragraph text or close to it.
-				if depth == 0 {
-					return i, nil
-				}
-				depth--
+		p, ok := items[i].(*docx.Paragraph)
+		if !ok {
+			continue
+		}
+		text := t.getParagraphText(p)
+		content, isTag := t.delims.blockTag(text)
+		if !isTag {
+			continue
+		}
+		if strings.HasPrefix(content, keyword+" ") {
+			depth++
+		} else if content == endTag {
+			if depth == 0 {
+				return i, nil
 			}
+			depth--
 		}
 	}
-	return -1, fmt.Errorf("block end {{%s}} not found", endTag)
+	return -1, &BlockError{Tag: endTag, Msg: fmt.Sprintf("block end %s not found", t.delims.tag(endTag))}
 }
 
 func (t *DocxTemplate) executeLoop(block []interface{}, variable, sliceExpr string, data interface{}) ([]interface{}, error) {
@@ -321,7 +634,13 @@ func (t *DocxTemplate) executeLoop(block []interface{}, variable, sliceExpr stri
 	var result []interface{}
 	sliceVal := reflect.ValueOf(slice)
 	if sliceVal.Kind() == reflect.Slice || sliceVal.Kind() == reflect.Array {
+		t.loops = append(t.loops, LoopFrame{Variable: variable, Source: sliceExpr})
+		defer func() { t.loops = t.loops[:len(t.loops)-1] }()
 		for i := 0; i < sliceVal.Len(); i++ {
+			t.loops[len(t.loops)-1].Index = i
+			if err := t.iterate(); err != nil {
+				return nil, err
+			}
 			item := sliceVal.Index(i).Interface()
 
 			// Create context: data + variable
@@ -421,50 +740,116 @@ func (t *DocxTemplate) cloneTable(tbl *docx.Table) (*docx.Table, error) {
 	return &newT, nil
 }
 
-func (t *DocxTemplate) processTable(table *docx.Table, data interface{}) error {
+// processTable renders the rows of table and reports whether the table
+// should be kept in the document
+func (t *DocxTemplate) processTable(table *docx.Table, data interface{}) (bool, error) {
 	var newRows []*docx.WTableRow
 
+	tableIndex := t.loc.Paragraph
+	if p, tag, expr, ok := t.findTableIf(table); ok {
+		val, err := t.evaluateExpression(expr, data)
+		if errors.Is(err, errVerbatim) {
+			return true, nil
+		}
+		text := t.getParagraphText(p)
+		t.replaceTextInParagraph(p, text, strings.Replace(text, tag, "", 1))
+		if err != nil {
+			// A broken condition keeps the table so that its rows are checked too
+			if !t.collect(err, expr, p) {
+				return false, t.newError(err, expr)
+			}
+			val = true
+		}
+		if !isTruthy(val) {
+			return false, nil
+		}
+	}
+
+	// Layout directives are applied before rows are cloned so that every
+	// generated row inherits them
+	for _, row := range table.TableRows {
+		t.applyRowDirectives(row)
+	}
+
+	ranges, generated := 0, 0
 	for i := 0; i < len(table.TableRows); i++ {
 		row := table.TableRows[i]
 		rangeCmd, rangeContent, hasRange := t.checkRowRange(row)
 		ifCmd, _, hasIf := t.checkRowIf(row)
+		tagPara, _, _, _ := t.findRowTag(row, "range")
+		if hasIf {
+			tagPara, _, _, _ = t.findRowTag(row, "if")
+		}
 
+		t.rowRef = CellRef{Table: tableIndex, Row: i}
 		if hasRange {
 			slice, err := t.evaluateExpression(rangeCmd, data)
+			if errors.Is(err, errVerbatim) {
+				newRows = append(newRows, row)
+				continue
+			}
 			if err != nil {
-				return err
+				if t.collect(err, rangeContent, tagPara) {
+					newRows = append(newRows, row)
+					continue
+				}
+				return false, t.newError(err, rangeContent)
 			}
+			ranges++
 
 			sliceVal := reflect.ValueOf(slice)
 			if sliceVal.Kind() == reflect.Slice || sliceVal.Kind() == reflect.Array {
+				t.loops = append(t.loops, LoopFrame{Source: rangeCmd})
 				for k := 0; k < sliceVal.Len(); k++ {
+					t.loops[len(t.loops)-1].Index = k
+					t.rowRef = CellRef{Table: tableIndex, Row: i}
+					if err := t.iterate(); err != nil {
+						return false, t.newError(err, rangeContent)
+					}
 					item := sliceVal.Index(k).Interface()
 
 					clonedRow, err := t.cloneRow(row)
 					if err != nil {
-						return err
+						return false, err
 					}
 
 					t.cleanRowRangeTag(clonedRow, rangeContent)
 
 					if err := t.processRow(clonedRow, item); err != nil {
-						return err
+						return false, err
 					}
 
 					newRows = append(newRows, clonedRow)
+					generated++
 				}
+				t.loops = t.loops[:len(t.loops)-1]
 			}
 		} else if hasIf {
 			// Find matching endif
 			endIdx := t.findRowBlockEnd(table.TableRows, i+1)
 			if endIdx == -1 {
-				return fmt.Errorf("missing {{endif}} for {{if %s}}", ifCmd)
+				err := &BlockError{Tag: "endif", Msg: fmt.Sprintf("missing %s for %s", t.delims.tag("endif"), t.delims.tag("if "+ifCmd))}
+				if t.collect(err, ifCmd, tagPara) {
+					newRows = append(newRows, row)
+					continue
+				}
+				return false, t.newError(err, ifCmd)
 			}
 
 			// Evaluate condition
 			val, err := t.evaluateExpression(ifCmd, data)
+			if errors.Is(err, errVerbatim) {
+				newRows = append(newRows, table.TableRows[i:endIdx+1]...)
+				i = endIdx
+				continue
+			}
 			if err != nil {
-				return err
+				if t.collect(err, ifCmd, tagPara) {
+					newRows = append(newRows, table.TableRows[i:endIdx+1]...)
+					i = endIdx
+					continue
+				}
+				return false, t.newError(err, ifCmd)
 			}
 
 			truthy := isTruthy(val)
@@ -479,10 +864,11 @@ func (t *DocxTemplate) processTable(table *docx.Table, data interface{}) error {
 					innerRow := table.TableRows[j]
 					clonedRow, err := t.cloneRow(innerRow)
 					if err != nil {
-						return err
+						return false, err
 					}
+					t.rowRef = CellRef{Table: tableIndex, Row: j}
 					if err := t.processRow(clonedRow, data); err != nil {
-						return err
+						return false, err
 					}
 					newRows = append(newRows, clonedRow)
 				}
@@ -491,43 +877,104 @@ func (t *DocxTemplate) processTable(table *docx.Table, data interface{}) error {
 			i = endIdx
 		} else {
 			if err := t.processRow(row, data); err != nil {
-				return err
+				return false, err
 			}
 			newRows = append(newRows, row)
 		}
 	}
 
 	table.TableRows = newRows
-	return nil
+	if t.opts.RemoveEmptyTables && ranges > 0 && generated == 0 {
+		return false, nil
+	}
+	// A table must hold at least one row
+	if len(newRows) == 0 {
+		return false, nil
+	}
+	return true, nil
+}
+
+// findTableIf finds a {{tableif expr}} tag in any cell of table and returns
+// its paragraph, the tag and its expression
+func (t *DocxTemplate) findTableIf(table *docx.Table) (*docx.Paragraph, string, string, bool) {
+	for _, row := range table.TableRows {
+		for _, cell := range row.TableCells {
+			for _, p := range cell.Paragraphs {
+				m := t.delims.tableIf.FindStringSubmatch(t.getParagraphText(p))
+				if m != nil {
+					return p, m[0], m[1], true
+				}
+			}
+		}
+	}
+	return nil, "", "", false
 }
 
 func (t *DocxTemplate) checkRowIf(row *docx.WTableRow) (string, string, bool) {
-	if len(row.TableCells) == 0 {
-		return "", "", false
+	_, tag, cmd, ok := t.findRowTag(row, "if")
+	return cmd, tag, ok
+}
+
+// findRowTag scans every paragraph of every cell in row for a row-level
+// control tag such as {{ range .Items }} and returns the paragraph holding it,
+// the tag text and its argument. Tags opening an inline block that is closed
+// by {{end}} in the same paragraph, and {{if}}/{{endif}} pairs that are both
+// inside the same cell, are not row-level tags.
+func (t *DocxTemplate) findRowTag(row *docx.WTableRow, keyword string) (*docx.Paragraph, string, string, bool) {
+	for _, cell := range row.TableCells {
+		for i, p := range cell.Paragraphs {
+			text := t.getParagraphText(p)
+			tag, arg, ok := t.parseRowTag(text, keyword)
+			if !ok {
+				continue
+			}
+			// Block tags with delimiters of their own are never inline
+			if (keyword == "range" || keyword == "if") && !t.delims.split() {
+				rest := text[strings.Index(text, tag)+len(tag):]
+				if t.delims.inlineEnd.MatchString(rest) {
+					continue
+				}
+			}
+			if keyword == "if" && t.cellHasTag(cell.Paragraphs[i+1:], "endif") {
+				continue
+			}
+			if keyword == "endif" && t.cellHasTag(cell.Paragraphs[:i], "if") {
+				continue
+			}
+			return p, tag, arg, true
+		}
 	}
-	cell := row.TableCells[0]
-	if len(cell.Paragraphs) == 0 {
-		return "", "", false
+	return nil, "", "", false
+}
+
+func (t *DocxTemplate) cellHasTag(paragraphs []*docx.Paragraph, keyword string) bool {
+	for _, p := range paragraphs {
+		if _, _, ok := t.parseRowTag(t.getParagraphText(p), keyword); ok {
+			return true
+		}
 	}
-	p := cell.Paragraphs[0]
+	return false
+}
 
-	text := t.getParagraphText(p)
+// parseRowTag reports whether text starts with a tag for keyword, accepting
+// {{keyword}}, {{ keyword }} and the {{- keyword -}} trim markers.
+func (t *DocxTemplate) parseRowTag(text, keyword string) (string, string, bool) {
+	left, right := t.delims.blockLeft, t.delims.blockRight
 	text = strings.TrimSpace(text)
-	if strings.HasPrefix(text, "{{if") || strings.HasPrefix(text, "{{ if") {
-		// Check if it's a block if (not inline)
-		// For row-level, we assume the whole cell text is the tag, or at least it starts with it.
-		// We need to extract the condition.
-		start := strings.Index(text, "{{")
-		end := strings.Index(text, "}}")
-		if start != -1 && end != -1 {
-			content := text[start : end+2]
-			cmd := strings.TrimSpace(text[start+2 : end])
-			cmd = strings.TrimPrefix(cmd, "if")
-			cmd = strings.TrimSpace(cmd)
-			return cmd, content, true
-		}
+	if !strings.HasPrefix(text, left) {
+		return "", "", false
 	}
-	return "", "", false
+	end := strings.Index(text[len(left):], right)
+	if end == -1 {
+		return "", "", false
+	}
+	end += len(left)
+	content := strings.TrimSpace(strings.TrimPrefix(text[len(left):end], "-"))
+	content = strings.TrimSpace(strings.TrimSuffix(content, "-"))
+	if content != keyword && !strings.HasPrefix(content, keyword+" ") {
+		return "", "", false
+	}
+	return text[:end+len(right)], strings.TrimSpace(strings.TrimPrefix(content, keyword)), true
 }
 
 func (t *DocxTemplate) findRowBlockEnd(rows []*docx.WTableRow, startIdx int) int {
@@ -536,77 +983,47 @@ func (t *DocxTemplate) findRowBlockEnd(rows []*docx.WTableRow, startIdx int) int
 		row := rows[i]
 		if _, _, hasIf := t.checkRowIf(row); hasIf {
 			depth++
-		} else {
-			// Check for endif
-			if len(row.TableCells) > 0 && len(row.TableCells[0].Paragraphs) > 0 {
-				p := row.TableCells[0].Paragraphs[0]
-				text := strings.TrimSpace(t.getParagraphText(p))
-				if strings.HasPrefix(text, "{{endif}}") || strings.HasPrefix(text, "{{ endif}}") {
-					if depth == 0 {
-						return i
-					}
-					depth--
-				}
+		} else if _, _, _, hasEnd := t.findRowTag(row, "endif"); hasEnd {
+			if depth == 0 {
+				return i
 			}
+			depth--
 		}
 	}
 	return -1
 }
 
 func (t *DocxTemplate) processRow(row *docx.WTableRow, data interface{}) error {
-	for _, cell := range row.TableCells {
-		var newParagraphs []*docx.Paragraph
-		for _, p := range cell.Paragraphs {
-			items, err := t.processParagraph(p, data)
-			if err != nil {
-				return err
-			}
-			if items != nil {
-				for _, item := range items {
-					if np, ok := item.(*docx.Paragraph); ok {
-						newParagraphs = append(newParagraphs, np)
-					}
-				}
-			} else {
-				newParagraphs = append(newParagraphs, p)
-			}
-		}
-		cell.Paragraphs = newParagraphs
-		for _, tbl := range cell.Tables {
-			if err := t.processTable(tbl, data); err != nil {
-				return err
-			}
+	parent := t.cell
+	ref := t.rowRef
+	saved, savedBase := t.loc, t.base
+	defer func() {
+		t.cell = parent
+		t.loc, t.base = saved, savedBase
+	}()
+
+	for c, cell := range row.TableCells {
+		t.cell = cell
+		t.loc = saved
+		t.loc.Cells = append(append([]CellRef(nil), saved.Cells...), CellRef{Table: ref.Table, Row: ref.Row, Cell: c})
+		t.base = 0
+		// Cells go through the same block traversal as the body
+		newItems, err := t.traverseItems(cellItems(cell), data)
+		if err != nil {
+			return err
 		}
+		setCellItems(cell, newItems)
 	}
 	return nil
 }
 
 func (t *DocxTemplate) checkRowRange(row *docx.WTableRow) (string, string, bool) {
-	if len(row.TableCells) == 0 {
-		return "", "", false
-	}
-	cell := row.TableCells[0]
-	if len(cell.Paragraphs) == 0 {
-		return "", "", false
-	}
-	p := cell.Paragraphs[0]
-
-	text := t.getParagraphText(p)
-	if strings.HasPrefix(strings.TrimSpace(text), "{{ range") {
-		start := strings.Index(text, "{{ range")
-		end := strings.Index(text, "}}")
-		if start != -1 && end != -1 {
-			content := text[start : end+2]
-			cmd := strings.TrimSpace(text[start+8 : end])
-			return cmd, content, true
-		}
-	}
-	return "", "", false
+	_, tag, cmd, ok := t.findRowTag(row, "range")
+	return cmd, tag, ok
 }
 
 func (t *DocxTemplate) cleanRowRangeTag(row *docx.WTableRow, tag string) {
-	if len(row.TableCells) > 0 && len(row.TableCells[0].Paragraphs) > 0 {
-		p := row.TableCells[0].Paragraphs[0]
+	if p, _, _, ok := t.findRowTag(row, "range"); ok {
 		text := t.getParagraphText(p)
 		newText := strings.Replace(text, tag, "", 1)
 		t.replaceTextInParagraph(p, text, newText)
@@ -699,82 +1116,91 @@ func (t *DocxTemplate) cloneParagraph(p *docx.Paragraph) (*docx.Paragraph, error
 }
 
 func (t *DocxTemplate) evaluateExpression(expr string, data interface{}) (interface{}, error) {
-	path := strings.TrimPrefix(expr, ".")
-	parts := strings.Split(path, ".")
-	val := reflect.ValueOf(data)
-
-	for _, part := range parts {
-		if part == "" {
-			continue
-		}
-		if val.Kind() == reflect.Ptr {
-			val = val.Elem()
-		}
-		if val.Kind() == reflect.Interface {
-			val = val.Elem()
-		}
-		if val.Kind() == reflect.Struct {
-			val = val.FieldByName(part)
-		} else if val.Kind() == reflect.Map {
-			val = val.MapIndex(reflect.ValueOf(part))
-		}
-		if !val.IsValid() {
-			return nil, fmt.Errorf("field %s not found", part)
+	path := expr
+	// Names bound by {{set}} take precedence over the data
+	if name, rest, _ := strings.Cut(expr, "."); name != "" {
+		if bound, ok := t.lookupVar(name); ok {
+			data, path = bound, rest
 		}
 	}
-
-	return val.Interface(), nil
+	val, omitted, err := t.lookup(data, path)
+	if err != nil {
+		return t.missingValue(expr, err)
+	}
+	if omitted {
+		// Empty fields omitted with OmitEmpty are false in block tags
+		return nil, nil
+	}
+	return val, nil
 }
 
 func (t *DocxTemplate) processParagraph(p *docx.Paragraph, data interface{}) ([]interface{}, error) {
+	defer t.clearMarks()
 	fullText := t.getParagraphText(p)
 
-	if !strings.Contains(fullText, "{{") {
+	if !t.delims.hasTags(fullText) {
 		return nil, nil
 	}
 
-	if t.funcs["inject"] == nil {
-		t.funcs["inject"] = func(v Injector) string {
-			id := fmt.Sprintf("__INJECT_%p__", v)
-			t.injectors[id] = v
-			return id
-		}
+	if t.delims.layout.MatchString(fullText) {
+		t.applyParagraphDirectives(p)
+		fullText = t.getParagraphText(p)
 	}
 
-	tmpl := template.New("p")
-	tmpl.Funcs(t.funcs)
-
-	if ctx, ok := data.(map[string]interface{}); ok {
-		funcMap := make(template.FuncMap)
-		for k, v := range ctx {
-			val := v
-			funcMap[k] = func() interface{} { return val }
+	// Escaped delimiters are hidden from the template and comments dropped
+	text := t.delims.stripComments(t.delims.escape(fullText))
+	if !strings.Contains(text, t.delims.left) {
+		if text != fullText {
+			t.replaceTextInParagraph(p, fullText, t.delims.unescape(text))
 		}
-		tmpl.Funcs(funcMap)
+		return nil, nil
+	}
+	marked, runLevel := t.markRuns(p)
+	if runLevel {
+		text = t.delims.stripComments(t.delims.escape(marked))
 	}
 
-	tmpl, err := tmpl.Parse(fullText)
+	tmpl, err := t.inlineTemplate(text, data, nil)
 	if err != nil {
-		return nil, err
+		return nil, t.newError(&TemplateError{Err: err}, fullText)
 	}
 
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, data); err != nil {
-		return nil, err
+		return nil, t.newError(inlineError(err), fullText)
 	}
 
-	renderedText := buf.String()
+	renderedText := t.delims.unescape(buf.String())
+	n := len(p.Children)
 
+	// inline are the runs injected in place of their placeholder
+	inline := make(map[string][]*docx.Run)
 	if strings.Contains(renderedText, "__INJECT_") {
 		for id, injector := range t.injectors {
 			if strings.Contains(renderedText, id) {
-				items, err := injector.Inject(t.doc, p)
+				res, err := injector.InjectV2(t.injectContext(p, data))
 				if err != nil {
-					return nil, err
+					return nil, t.newError(&InjectError{Injector: injected(injector), Err: err}, fullText)
 				}
-
-				if items != nil {
-					return items, nil
+				if res.Block {
+					if err := t.countImages(res.Items...); err != nil {
+						return nil, t.newError(err, fullText)
+					}
+					if res.Items == nil {
+						return []interface{}{}, nil
+					}
+					return res.Items, nil
+				}
+				added := &docx.Paragraph{}
+				for _, r := range res.Runs {
+					added.Children = append(added.Children, r)
+				}
+				if err := t.countImages(p, added); err != nil {
+					return nil, t.newError(err, fullText)
+				}
+				if len(res.Runs) > 0 {
+					inline[id] = res.Runs
+					continue
 				}
 
 				renderedText = strings.Replace(renderedText, id, "", -1)
@@ -782,10 +1208,105 @@ func (t *DocxTemplate) processParagraph(p *docx.Paragraph, data interface{}) ([]
 		}
 	}
 
-	t.replaceTextInParagraph(p, fullText, renderedText)
+	if runLevel {
+		splitRuns(p, n, marked, renderedText)
+	} else {
+		t.replaceTextInParagraph(p, fullText, renderedText)
+	}
+	for id, runs := range inline {
+		placeRuns(p, id, runs)
+	}
+	if err := t.applyStyles(p); err != nil {
+		return nil, t.newError(err, fullText)
+	}
+
+	// A paragraph made of tags that renders to nothing is removed when asked
+	// for, either by the render options or by {{- -}} trim markers around it
+	trimmed := strings.TrimSpace(runMarkRe.ReplaceAllString(text, ""))
+	trim := strings.HasPrefix(trimmed, t.delims.left+"-") && strings.HasSuffix(trimmed, "-"+t.delims.right)
+	if (t.opts.RemoveEmptyParagraphs || trim) && isEmptyParagraph(p) {
+		return []interface{}{}, nil
+	}
 	return nil, nil
 }
 
+// clearMarks forgets the style and injector placeholders of the paragraph
+// just rendered
+func (t *DocxTemplate) clearMarks() {
+	clear(t.styles)
+	clear(t.injectors)
+}
+
+// isEmptyParagraph reports whether p holds no visible content
+func isEmptyParagraph(p *docx.Paragraph) bool {
+	for _, child := range p.Children {
+		switch c := child.(type) {
+		case *docx.Run:
+			for _, rc := range c.Children {
+				text, ok := rc.(*docx.Text)
+				if !ok || strings.TrimSpace(text.Text) != "" {
+					return false
+				}
+			}
+		case *layoutMarker:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// inlineTemplate parses the inline actions of text, registering the template
+// functions, the keys of data and the {{set}} bindings, plus extra if any
+func (t *DocxTemplate) inlineTemplate(text string, data interface{}, extra template.FuncMap) (*template.Template, error) {
+	if t.funcs["inject"] == nil {
+		t.funcs["inject"] = func(v interface{}) (string, error) {
+			inj, err := injectable(v)
+			if err != nil {
+				return "", err
+			}
+			id := fmt.Sprintf("__INJECT_%d__", t.marks)
+			t.marks++
+			t.injectors[id] = inj
+			return id, nil
+		}
+	}
+	if t.funcs["cellColor"] == nil {
+		for k, v := range t.styleFuncs() {
+			t.funcs[k] = v
+		}
+	}
+
+	tmpl := template.New("p").Delims(t.delims.left, t.delims.right)
+	tmpl.Funcs(t.funcs)
+
+	ctx, _ := data.(map[string]interface{})
+	if ctx != nil {
+		funcMap := make(template.FuncMap)
+		for k, v := range ctx {
+			val := v
+			funcMap[k] = func() interface{} { return val }
+		}
+		tmpl.Funcs(funcMap)
+	}
+	tmpl.Funcs(t.varFuncs())
+	if extra != nil {
+		tmpl.Funcs(extra)
+	}
+
+	return t.parseInline(tmpl, text, ctx)
+}
+
+// inlineError returns the error kind of a failed inline action. Missing
+// values reported through a missing-key policy keep their kind.
+func inlineError(err error) error {
+	var fe *FieldError
+	if errors.As(err, &fe) {
+		return fe
+	}
+	return &TemplateError{Err: err}
+}
+
 func (t *DocxTemplate) getParagraphText(p *docx.Paragraph) string {
 	fullText := ""
 	for _, child := range p.Children {
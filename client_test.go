@@ -0,0 +1,84 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"slices"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// newTestTemplate returns a template whose body is added by fill
+func newTestTemplate(t *testing.T, fill func(doc *docx.Docx)) *DocxTemplate {
+	t.Helper()
+	data := packageOf(t, fill)
+	tpl, err := New(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tpl
+}
+
+// packageOf returns a .docx package whose body is added by fill
+func packageOf(t *testing.T, fill func(doc *docx.Docx)) []byte {
+	t.Helper()
+	doc := docx.New().WithDefaultTheme()
+	fill(doc)
+	var buf bytes.Buffer
+	if _, err := doc.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// textTemplate returns a template with a paragraph holding each line
+func textTemplate(t *testing.T, lines ...string) *DocxTemplate {
+	t.Helper()
+	return newTestTemplate(t, func(doc *docx.Docx) {
+		for _, line := range lines {
+			doc.AddParagraph().AddText(line)
+		}
+	})
+}
+
+// texts returns the text of the paragraphs of the body of tpl
+func texts(tpl *DocxTemplate) []string {
+	var out []string
+	for _, item := range tpl.doc.Document.Body.Items {
+		if p, ok := item.(*docx.Paragraph); ok {
+			out = append(out, tpl.getParagraphText(p))
+		}
+	}
+	return out
+}
+
+// savedPart returns the part name of the package saved from tpl
+func savedPart(t *testing.T, tpl *DocxTemplate, name string) string {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := tpl.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := readPart(zr, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data)
+}
+
+func TestRender(t *testing.T) {
+	tpl := textTemplate(t, "Hello {{ .Name }}", "plain")
+	if err := tpl.Render(map[string]interface{}{"Name": "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	got := texts(tpl)
+	want := []string{"Hello Ann", "plain"}
+	if !slices.Equal(got, want) {
+		t.Errorf("texts = %q, want %q", got, want)
+	}
+}
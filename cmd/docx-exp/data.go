@@ -0,0 +1,65 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// decodeData decodes a JSON or YAML mapping
+func decodeData(b []byte) (map[string]interface{}, error) {
+	var v interface{}
+	if trimmed := bytes.TrimSpace(b); len(trimmed) > 0 && (trimmed[0] == '{' || trimmed[0] == '[') {
+		if err := json.Unmarshal(trimmed, &v); err != nil {
+			return nil, err
+		}
+	} else if err := yaml.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	if v == nil {
+		return make(map[string]interface{}), nil
+	}
+	data, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("expected a mapping at the top level, got %T", v)
+	}
+	return data, nil
+}
+
+// setValue sets the dotted path key of data to value, creating the mappings
+// on the way. value is read as a YAML scalar, so numbers and booleans keep
+// their type, and is kept as a string when it is not one.
+func setValue(data map[string]interface{}, key, value string) error {
+	parts := strings.Split(strings.TrimPrefix(key, "."), ".")
+	for _, part := range parts[:len(parts)-1] {
+		if part == "" {
+			return fmt.Errorf("empty key in %q", key)
+		}
+		next, ok := data[part].(map[string]interface{})
+		if !ok {
+			if _, exists := data[part]; exists {
+				return fmt.Errorf("%s is not a mapping", part)
+			}
+			next = make(map[string]interface{})
+			data[part] = next
+		}
+		data = next
+	}
+	last := parts[len(parts)-1]
+	if last == "" {
+		return fmt.Errorf("empty key in %q", key)
+	}
+	var v interface{} = value
+	var scalar interface{}
+	if err := yaml.Unmarshal([]byte(value), &scalar); err == nil {
+		switch scalar.(type) {
+		case int, float64, bool:
+			v = scalar
+		}
+	}
+	data[last] = v
+	return nil
+}
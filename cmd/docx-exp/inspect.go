@@ -0,0 +1,422 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/fumiama/go-docx"
+	docxexp "github.com/little-yangyang/docx-exp"
+)
+
+// report is the output of docx-exp inspect
+type report struct {
+	Body          []*node              `json:"body"`
+	Styles        []style              `json:"styles"`
+	ContentTypes  []contentType        `json:"contentTypes"`
+	Relationships []relationship       `json:"relationships"`
+	Variables     *docxexp.Scope       `json:"variables"`
+	Tags          []docxexp.Tag        `json:"tags"`
+	Diagnostics   []docxexp.Diagnostic `json:"diagnostics"`
+}
+
+// node is an element of the document body
+type node struct {
+	// Kind is "paragraph", "run", "hyperlink", "drawing", "table", "row",
+	// "cell" or "section"
+	Kind     string  `json:"kind"`
+	Style    string  `json:"style,omitempty"`
+	Text     string  `json:"text,omitempty"`
+	ID       string  `json:"id,omitempty"`
+	Target   string  `json:"target,omitempty"`
+	Children []*node `json:"children,omitempty"`
+}
+
+type style struct {
+	ID      string `json:"id"`
+	Type    string `json:"type"`
+	Name    string `json:"name"`
+	Default bool   `json:"default,omitempty"`
+}
+
+// xmlStyle is a style of word/styles.xml
+type xmlStyle struct {
+	ID      string `xml:"styleId,attr"`
+	Type    string `xml:"type,attr"`
+	Default string `xml:"default,attr"`
+	Name    struct {
+		Val string `xml:"val,attr"`
+	} `xml:"name"`
+}
+
+type contentType struct {
+	Extension   string `xml:"Extension,attr" json:"extension,omitempty"`
+	PartName    string `xml:"PartName,attr" json:"partName,omitempty"`
+	ContentType string `xml:"ContentType,attr" json:"contentType"`
+}
+
+type relationship struct {
+	ID         string `xml:"Id,attr" json:"id"`
+	Type       string `xml:"Type,attr" json:"type"`
+	Target     string `xml:"Target,attr" json:"target"`
+	TargetMode string `xml:"TargetMode,attr" json:"targetMode,omitempty"`
+}
+
+// lintResult is the JSON output of docx-exp lint for one template
+type lintResult struct {
+	File        string               `json:"file"`
+	Error       string               `json:"error,omitempty"`
+	Diagnostics []docxexp.Diagnostic `json:"diagnostics"`
+}
+
+// runInspect implements docx-exp inspect
+func runInspect(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "write JSON")
+	lint := fs.Bool("lint", false, "only report the problems found, as docx-exp lint")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: docx-exp inspect [-json] [-lint] template.docx")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *lint {
+		return lintFiles(fs.Args(), *asJSON, stdin, stdout)
+	}
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return exitError(2)
+	}
+	src, err := readInput(fs.Arg(0), stdin)
+	if err != nil {
+		return err
+	}
+	r, err := inspect(src)
+	if err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+	if *asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	return r.write(stdout)
+}
+
+// runLint implements docx-exp lint. The exit status is 1 when a template
+// has problems or cannot be read.
+func runLint(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("lint", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "write JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: docx-exp lint [-json] template.docx...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	return lintFiles(fs.Args(), *asJSON, stdin, stdout)
+}
+
+func lintFiles(files []string, asJSON bool, stdin io.Reader, stdout io.Writer) error {
+	if len(files) == 0 {
+		return errors.New("no templates given")
+	}
+	failed := false
+	results := make([]lintResult, 0, len(files))
+	for _, file := range files {
+		res := lintResult{File: file, Diagnostics: []docxexp.Diagnostic{}}
+		src, err := readInput(file, stdin)
+		var tpl *docxexp.DocxTemplate
+		if err == nil {
+			tpl, err = docxexp.New(bytes.NewReader(src), int64(len(src)))
+		}
+		if err != nil {
+			res.Error = err.Error()
+		} else if diags := tpl.Lint(); len(diags) > 0 {
+			res.Diagnostics = diags
+		}
+		failed = failed || res.Error != "" || len(res.Diagnostics) > 0
+		results = append(results, res)
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, res := range results {
+			if res.Error != "" {
+				fmt.Fprintf(stdout, "%s: %s\n", res.File, res.Error)
+			}
+			for _, d := range res.Diagnostics {
+				fmt.Fprintf(stdout, "%s: %s\n", res.File, d)
+			}
+		}
+	}
+	if failed {
+		return exitError(1)
+	}
+	return nil
+}
+
+// inspect reads the package src
+func inspect(src []byte) (*report, error) {
+	tpl, err := docxexp.New(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return nil, err
+	}
+	doc, err := docx.Parse(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return nil, err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return nil, err
+	}
+	in := tpl.Inspect()
+	r := &report{
+		Body:          bodyNodes(doc, doc.Document.Body.Items),
+		Styles:        []style{},
+		ContentTypes:  []contentType{},
+		Relationships: []relationship{},
+		Variables:     in.Root,
+		Tags:          in.Tags,
+		Diagnostics:   in.Diagnostics,
+	}
+	if r.Tags == nil {
+		r.Tags = []docxexp.Tag{}
+	}
+	if r.Diagnostics == nil {
+		r.Diagnostics = []docxexp.Diagnostic{}
+	}
+
+	var styles struct {
+		Styles []xmlStyle `xml:"style"`
+	}
+	if err := readXML(zr, "word/styles.xml", &styles); err != nil {
+		return nil, err
+	}
+	for _, s := range styles.Styles {
+		r.Styles = append(r.Styles, style{
+			ID:      s.ID,
+			Type:    s.Type,
+			Name:    s.Name.Val,
+			Default: s.Default == "1" || s.Default == "true",
+		})
+	}
+
+	var types struct {
+		Defaults  []contentType `xml:"Default"`
+		Overrides []contentType `xml:"Override"`
+	}
+	if err := readXML(zr, "[Content_Types].xml", &types); err != nil {
+		return nil, err
+	}
+	r.ContentTypes = append(append(r.ContentTypes, types.Defaults...), types.Overrides...)
+
+	var rels struct {
+		Relationships []relationship `xml:"Relationship"`
+	}
+	if err := readXML(zr, "word/_rels/document.xml.rels", &rels); err != nil {
+		return nil, err
+	}
+	r.Relationships = append(r.Relationships, rels.Relationships...)
+	return r, nil
+}
+
+// readXML decodes the part name of zr into v, leaving v untouched when the
+// package has no such part
+func readXML(zr *zip.Reader, name string, v interface{}) error {
+	f, err := zr.Open(name)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	if err := xml.NewDecoder(f).Decode(v); err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	return nil
+}
+
+// bodyNodes returns the tree of the body items
+func bodyNodes(doc *docx.Docx, items []interface{}) []*node {
+	nodes := []*node{}
+	for _, item := range items {
+		switch it := item.(type) {
+		case *docx.Paragraph:
+			nodes = append(nodes, paragraphNode(doc, it))
+		case *docx.Table:
+			nodes = append(nodes, tableNode(doc, it))
+		case *docx.SectPr:
+			nodes = append(nodes, &node{Kind: "section"})
+		}
+	}
+	return nodes
+}
+
+func paragraphNode(doc *docx.Docx, p *docx.Paragraph) *node {
+	n := &node{Kind: "paragraph"}
+	if p.Properties != nil && p.Properties.Style != nil {
+		n.Style = p.Properties.Style.Val
+	}
+	for _, child := range p.Children {
+		switch c := child.(type) {
+		case *docx.Run:
+			n.Children = append(n.Children, runNodes(doc, c)...)
+		case *docx.Hyperlink:
+			link := &node{Kind: "hyperlink", ID: c.ID, Children: runNodes(doc, &c.Run)}
+			link.Target, _ = doc.ReferTarget(c.ID)
+			n.Children = append(n.Children, link)
+		}
+	}
+	return n
+}
+
+// runNodes returns the run r, followed by the drawings it holds
+func runNodes(doc *docx.Docx, r *docx.Run) []*node {
+	n := &node{Kind: "run"}
+	if r.RunProperties != nil && r.RunProperties.RunStyle != nil {
+		n.Style = r.RunProperties.RunStyle.Val
+	}
+	nodes := []*node{n}
+	var sb strings.Builder
+	for _, child := range r.Children {
+		switch c := child.(type) {
+		case *docx.Text:
+			sb.WriteString(c.Text)
+		case *docx.Tab:
+			sb.WriteString("\t")
+		case *docx.BarterRabbet:
+			sb.WriteString("\n")
+		case *docx.Drawing:
+			nodes = append(nodes, drawingNode(doc, c))
+		}
+	}
+	n.Text = sb.String()
+	if n.Text == "" && n.Style == "" {
+		nodes = nodes[1:]
+	}
+	return nodes
+}
+
+func drawingNode(doc *docx.Docx, d *docx.Drawing) *node {
+	n := &node{Kind: "drawing"}
+	var graphic *docx.AGraphic
+	switch {
+	case d.Inline != nil:
+		graphic = d.Inline.Graphic
+	case d.Anchor != nil:
+		graphic = d.Anchor.Graphic
+	}
+	if graphic != nil && graphic.GraphicData != nil && graphic.GraphicData.Pic != nil &&
+		graphic.GraphicData.Pic.BlipFill != nil {
+		n.ID = graphic.GraphicData.Pic.BlipFill.Blip.Embed
+		n.Target, _ = doc.ReferTarget(n.ID)
+	}
+	return n
+}
+
+func tableNode(doc *docx.Docx, t *docx.Table) *node {
+	n := &node{Kind: "table"}
+	for _, row := range t.TableRows {
+		rn := &node{Kind: "row"}
+		for _, cell := range row.TableCells {
+			var items []interface{}
+			for _, p := range cell.Paragraphs {
+				items = append(items, p)
+			}
+			for _, tbl := range cell.Tables {
+				items = append(items, tbl)
+			}
+			rn.Children = append(rn.Children, &node{Kind: "cell", Children: bodyNodes(doc, items)})
+		}
+		n.Children = append(n.Children, rn)
+	}
+	return n
+}
+
+// write prints the report as text
+func (r *report) write(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Body")
+	for i, n := range r.Body {
+		writeNode(tw, n, fmt.Sprintf("[%d] ", i), "  ")
+	}
+
+	fmt.Fprintln(tw, "\nStyles")
+	for _, s := range r.Styles {
+		def := ""
+		if s.Default {
+			def = "default"
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", s.ID, s.Type, s.Name, def)
+	}
+
+	fmt.Fprintln(tw, "\nContent Types")
+	for _, ct := range r.ContentTypes {
+		if ct.Extension != "" {
+			fmt.Fprintf(tw, "  *.%s\t%s\n", ct.Extension, ct.ContentType)
+		} else {
+			fmt.Fprintf(tw, "  %s\t%s\n", ct.PartName, ct.ContentType)
+		}
+	}
+
+	fmt.Fprintln(tw, "\nRelationships")
+	for _, rel := range r.Relationships {
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\n", rel.ID, path.Base(rel.Type), rel.Target, rel.TargetMode)
+	}
+
+	fmt.Fprintln(tw, "\nTags")
+	for _, tag := range r.Tags {
+		fmt.Fprintf(tw, "  %s\t%s\n", tag.Location, oneLine(tag.Text))
+	}
+
+	if len(r.Diagnostics) > 0 {
+		fmt.Fprintln(tw, "\nDiagnostics")
+		for _, d := range r.Diagnostics {
+			fmt.Fprintf(tw, "  %s\n", d)
+		}
+	}
+	return tw.Flush()
+}
+
+func writeNode(w io.Writer, n *node, label, indent string) {
+	fmt.Fprintf(w, "%s%s%s", indent, label, n.Kind)
+	if n.Style != "" {
+		fmt.Fprintf(w, " style=%s", n.Style)
+	}
+	if n.ID != "" {
+		fmt.Fprintf(w, " %s", n.ID)
+	}
+	if n.Target != "" {
+		fmt.Fprintf(w, " -> %s", n.Target)
+	}
+	if n.Text != "" {
+		fmt.Fprintf(w, " %q", n.Text)
+	}
+	fmt.Fprintln(w)
+	for i, c := range n.Children {
+		label := ""
+		if c.Kind == "row" || c.Kind == "cell" {
+			label = fmt.Sprintf("[%d] ", i)
+		}
+		writeNode(w, c, label, indent+"  ")
+	}
+}
+
+// oneLine replaces the line breaks of s with spaces
+func oneLine(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
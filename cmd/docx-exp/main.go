@@ -0,0 +1,63 @@
+// Command docx-exp renders and checks docx templates
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+const usage = `Usage: docx-exp <command> [flags]
+
+Commands:
+  render   render a template with JSON or YAML data
+  inspect  print the structure and the tags of a template
+  lint     report the problems of templates, exiting with status 1 if any
+  serve    render templates over HTTP
+
+Run "docx-exp <command> -h" for the flags of a command.
+`
+
+// commands are the subcommands by name
+var commands = map[string]func(args []string, stdin io.Reader, stdout io.Writer) error{
+	"render":  runRender,
+	"inspect": runInspect,
+	"lint":    runLint,
+	"serve":   runServe,
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	run, ok := commands[os.Args[1]]
+	if !ok {
+		if os.Args[1] != "-h" && os.Args[1] != "help" && os.Args[1] != "--help" {
+			fmt.Fprintf(os.Stderr, "docx-exp: unknown command %q\n", os.Args[1])
+		}
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+	if err := run(os.Args[2:], os.Stdin, os.Stdout); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			os.Exit(0)
+		}
+		var exit exitError
+		if !errors.As(err, &exit) {
+			fmt.Fprintf(os.Stderr, "docx-exp %s: %v\n", os.Args[1], err)
+			exit = 1
+		}
+		os.Exit(int(exit))
+	}
+}
+
+// exitError ends the command with its status and no message, for errors
+// already reported
+type exitError int
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", int(e))
+}
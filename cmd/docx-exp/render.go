@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	docxexp "github.com/little-yangyang/docx-exp"
+)
+
+// runRender implements docx-exp render
+func runRender(args []string, stdin io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("render", flag.ContinueOnError)
+	var (
+		tplPath, dataPath, outPath string
+		strict                     bool
+		sets                       []string
+	)
+	fs.StringVar(&tplPath, "t", "", "template `file`, - for standard input")
+	fs.StringVar(&tplPath, "template", "", "alias for -t")
+	fs.StringVar(&dataPath, "d", "", "JSON or YAML data `file`, - for standard input")
+	fs.StringVar(&dataPath, "data", "", "alias for -d")
+	fs.StringVar(&outPath, "o", "-", "output `file`, - for standard output")
+	fs.StringVar(&outPath, "output", "-", "alias for -o")
+	fs.BoolVar(&strict, "strict", false, "fail on values missing from the data")
+	fs.Func("set", "set the data value at a dotted `key=value` path, may be repeated", func(s string) error {
+		if !strings.Contains(s, "=") {
+			return errors.New("expected key=value")
+		}
+		sets = append(sets, s)
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: docx-exp render -t template.docx [-d data.json] [-o out.docx] [--strict] [--set key=value]...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if tplPath == "" {
+		return errors.New("a template is required, see -t")
+	}
+	if tplPath == "-" && dataPath == "-" {
+		return errors.New("the template and the data cannot both be read from standard input")
+	}
+
+	src, err := readInput(tplPath, stdin)
+	if err != nil {
+		return err
+	}
+	tpl, err := docxexp.New(bytes.NewReader(src), int64(len(src)))
+	if err != nil {
+		return fmt.Errorf("template %s: %w", tplPath, err)
+	}
+
+	data := make(map[string]interface{})
+	if dataPath != "" {
+		b, err := readInput(dataPath, stdin)
+		if err != nil {
+			return err
+		}
+		if data, err = decodeData(b); err != nil {
+			return fmt.Errorf("data %s: %w", dataPath, err)
+		}
+	}
+	for _, s := range sets {
+		key, value, _ := strings.Cut(s, "=")
+		if err := setValue(data, key, value); err != nil {
+			return fmt.Errorf("--set %s: %w", s, err)
+		}
+	}
+	render, err := docxexp.NewDecoder().Convert(data)
+	if err != nil {
+		return fmt.Errorf("data: %w", err)
+	}
+
+	var opts docxexp.RenderOptions
+	if strict {
+		opts.MissingKey = docxexp.MissingKeyError
+	}
+	if err := tpl.RenderWithOptions(render, opts); err != nil {
+		return err
+	}
+
+	// Render fully before creating the output so errors leave no file behind
+	var out bytes.Buffer
+	if err := tpl.Save(&out); err != nil {
+		return err
+	}
+	if outPath == "-" {
+		_, err = stdout.Write(out.Bytes())
+		return err
+	}
+	return os.WriteFile(outPath, out.Bytes(), 0o644)
+}
+
+// readInput reads the file path, or in when path is -
+func readInput(path string, in io.Reader) ([]byte, error) {
+	if path == "-" {
+		return io.ReadAll(in)
+	}
+	return os.ReadFile(path)
+}
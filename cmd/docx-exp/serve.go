@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"time"
+
+	docxexp "github.com/little-yangyang/docx-exp"
+)
+
+// runServe implements docx-exp serve
+func runServe(args []string, _ io.Reader, stdout io.Writer) error {
+	fs := flag.NewFlagSet("serve", flag.ContinueOnError)
+	var opts docxexp.ServerOptions
+	addr := fs.String("addr", "localhost:8080", "listen `address`")
+	dir := fs.String("templates", "", "register the .docx files of `dir`, named after their base names, and open {{include}} paths from it")
+	strict := fs.Bool("strict", false, "fail renders on values missing from the data")
+	fs.Int64Var(&opts.MaxTemplateSize, "max-template", 32<<20, "maximum size of an uploaded template in `bytes`")
+	fs.Int64Var(&opts.MaxDataSize, "max-data", 4<<20, "maximum size of the data of a render in `bytes`")
+	fs.DurationVar(&opts.RenderTimeout, "timeout", 30*time.Second, "maximum `duration` of a render")
+	fs.BoolVar(&opts.ReadOnly, "read-only", false, "reject template uploads and deletions")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: docx-exp serve [-addr host:port] [-templates dir] [flags]")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 0 {
+		return fmt.Errorf("unexpected argument %q", fs.Arg(0))
+	}
+	if *strict {
+		opts.Render.MissingKey = docxexp.MissingKeyError
+	}
+	if *dir != "" {
+		opts.Render.IncludeFS = os.DirFS(*dir)
+	}
+	srv := docxexp.NewServer(opts)
+	if *dir != "" {
+		if err := registerDir(srv, *dir); err != nil {
+			return err
+		}
+	}
+
+	hs := &http.Server{
+		Addr:              *addr,
+		Handler:           srv,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdown, cancel := context.WithTimeout(context.Background(), opts.RenderTimeout)
+		defer cancel()
+		hs.Shutdown(shutdown)
+	}()
+	fmt.Fprintf(stdout, "docx-exp serve: listening on %s\n", *addr)
+	if err := hs.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// registerDir registers the templates of dir
+func registerDir(srv *docxexp.Server, dir string) error {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.docx"))
+	if err != nil {
+		return err
+	}
+	for _, path := range paths {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		t, err := docxexp.New(bytes.NewReader(b), int64(len(b)))
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".docx")
+		if err := srv.Register(name, t); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for _, d := range t.Lint() {
+			log.Printf("%s: %s", path, d)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package docxexp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// ContextInjector is an Injector that is given the context of RenderContext,
+// for example to cancel the downloads it makes
+type ContextInjector interface {
+	Injector
+	InjectWithContext(ctx context.Context, doc *docx.Docx, p *docx.Paragraph) ([]interface{}, error)
+}
+
+// Limits caps the resources a render may use. Zero fields are unlimited.
+type Limits struct {
+	// MaxIterations caps the iterations of {{for}} blocks and table row
+	// {{ range }} loops, summed over the render
+	MaxIterations int64
+	// MaxParagraphs caps the paragraphs rendered, table cells included
+	MaxParagraphs int64
+	// MaxImageBytes caps the size of the images added by injectors, summed
+	// over the render
+	MaxImageBytes int64
+	// MaxDepth caps the nesting of blocks, macro calls, includes and tables
+	MaxDepth int
+}
+
+// LimitError reports that a render went over one of its Limits
+type LimitError struct {
+	// Limit is the name of the Limits field
+	Limit string
+	Max   int64
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("render limit %s of %d exceeded", e.Limit, e.Max)
+}
+
+// usage counts the resources used by a render, shared with the templates it
+// includes
+type usage struct {
+	iterations, paragraphs, imageBytes int64
+	depth                              int
+	// images are the images counted or found in the templates
+	images map[imageKey]bool
+}
+
+// imageKey identifies an image by its document, as included templates have
+// relationship IDs of their own
+type imageKey struct {
+	doc *docx.Docx
+	rid string
+}
+
+// RenderContext renders the template as RenderWithOptions does, stopping with
+// the error of ctx once it is done. ctx is checked before each item and loop
+// iteration and is passed to ContextInjectors.
+func (t *DocxTemplate) RenderContext(ctx context.Context, data interface{}, opts RenderOptions) error {
+	t.opts = opts
+	t.changed = true
+	t.ctx = ctx
+	t.usage = &usage{images: make(map[imageKey]bool)}
+	defer func() { t.ctx, t.usage = nil, nil }()
+	t.loc = Location{Part: documentPart}
+	t.loops = nil
+	t.base = 0
+	t.errs = nil
+	t.vars = nil
+	t.macros = t.collectMacros(t.doc.Document.Body.Items)
+	t.calls = 0
+	t.seeImages(t.doc.Document.Body.Items)
+	newItems, err := t.traverseItems(t.doc.Document.Body.Items, data)
+	if err != nil {
+		return t.newError(err, "")
+	}
+	t.doc.Document.Body.Items = newItems
+	if len(t.errs) > 0 {
+		return t.errs
+	}
+	return nil
+}
+
+// checkContext returns the error of the render context once it is done
+func (t *DocxTemplate) checkContext() error {
+	if t.ctx == nil {
+		return nil
+	}
+	return t.ctx.Err()
+}
+
+// enter accounts for one more level of nesting; leave must be called after
+func (t *DocxTemplate) enter() error {
+	if t.usage == nil {
+		return nil
+	}
+	t.usage.depth++
+	if max := t.opts.Limits.MaxDepth; max > 0 && t.usage.depth > max {
+		return &LimitError{Limit: "MaxDepth", Max: int64(max)}
+	}
+	return nil
+}
+
+func (t *DocxTemplate) leave() {
+	if t.usage != nil {
+		t.usage.depth--
+	}
+}
+
+// iterate accounts for one loop iteration
+func (t *DocxTemplate) iterate() error {
+	if err := t.checkContext(); err != nil {
+		return err
+	}
+	if t.usage == nil {
+		return nil
+	}
+	t.usage.iterations++
+	if max := t.opts.Limits.MaxIterations; max > 0 && t.usage.iterations > max {
+		return &LimitError{Limit: "MaxIterations", Max: max}
+	}
+	return nil
+}
+
+// countParagraphs accounts for the paragraphs among items
+func (t *DocxTemplate) countParagraphs(items ...interface{}) error {
+	if t.usage == nil {
+		return nil
+	}
+	for _, item := range items {
+		if _, ok := item.(*docx.Paragraph); ok {
+			t.usage.paragraphs++
+		}
+	}
+	if max := t.opts.Limits.MaxParagraphs; max > 0 && t.usage.paragraphs > max {
+		return &LimitError{Limit: "MaxParagraphs", Max: max}
+	}
+	return nil
+}
+
+// countImages accounts for the images of items not counted or seen yet
+func (t *DocxTemplate) countImages(items ...interface{}) error {
+	if t.usage == nil {
+		return nil
+	}
+	drawings(items, func(rid string) {
+		key := imageKey{t.doc, rid}
+		if t.usage.images[key] {
+			return
+		}
+		t.usage.images[key] = true
+		target, err := t.doc.ReferTarget(rid)
+		if err != nil {
+			return
+		}
+		if m := t.doc.Media(strings.TrimPrefix(target, "media/")); m != nil {
+			t.usage.imageBytes += int64(len(m.Data))
+		}
+	})
+	if max := t.opts.Limits.MaxImageBytes; max > 0 && t.usage.imageBytes > max {
+		return &LimitError{Limit: "MaxImageBytes", Max: max}
+	}
+	return nil
+}
+
+// seeImages marks the images of items, found in the template, as not added
+// by injectors
+func (t *DocxTemplate) seeImages(items []interface{}) {
+	drawings(items, func(rid string) { t.usage.images[imageKey{t.doc, rid}] = true })
+}
+
+// drawings calls f with the relationship ID of each image in items
+func drawings(items []interface{}, f func(rid string)) {
+	for _, item := range items {
+		switch it := item.(type) {
+		case *docx.Paragraph:
+			for _, child := range it.Children {
+				r, ok := child.(*docx.Run)
+				if !ok {
+					continue
+				}
+				for _, rc := range r.Children {
+					if d, ok := rc.(*docx.Drawing); ok {
+						if rid := drawingEmbed(d); rid != "" {
+							f(rid)
+						}
+					}
+				}
+			}
+		case *docx.Table:
+			for _, row := range it.TableRows {
+				for _, cell := range row.TableCells {
+					for _, p := range cell.Paragraphs {
+						drawings([]interface{}{p}, f)
+					}
+					for _, tbl := range cell.Tables {
+						drawings([]interface{}{tbl}, f)
+					}
+				}
+			}
+		}
+	}
+}
+
+// drawingEmbed returns the relationship ID of the picture of d, if any
+func drawingEmbed(d *docx.Drawing) string {
+	var graphic *docx.AGraphic
+	switch {
+	case d.Inline != nil:
+		graphic = d.Inline.Graphic
+	case d.Anchor != nil:
+		graphic = d.Anchor.Graphic
+	}
+	if graphic == nil || graphic.GraphicData == nil || graphic.GraphicData.Pic == nil ||
+		graphic.GraphicData.Pic.BlipFill == nil {
+		return ""
+	}
+	return graphic.GraphicData.Pic.BlipFill.Blip.Embed
+}
+
+// aborts reports whether err stops the render even when errors are collected
+func aborts(err error) bool {
+	var le *LimitError
+	return errors.As(err, &le) || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
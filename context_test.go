@@ -0,0 +1,145 @@
+package docxexp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fumiama/go-docx"
+)
+
+func TestRenderContext(t *testing.T) {
+	data := map[string]interface{}{"Items": []int{1, 2, 3}}
+	lines := []string{"{{for v in Items}}", "{{ v }}{{ stop v }}", "{{endfor}}"}
+
+	t.Run("cancelled before", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		tpl := textTemplate(t, lines...)
+		tpl.Funcs(map[string]interface{}{"stop": func(int) string { return "" }})
+		if err := tpl.RenderContext(ctx, data, RenderOptions{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+	})
+
+	t.Run("cancelled in a loop", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		var seen []int
+		tpl := textTemplate(t, lines...)
+		tpl.Funcs(map[string]interface{}{"stop": func(v int) string {
+			seen = append(seen, v)
+			cancel()
+			return ""
+		}})
+		if err := tpl.RenderContext(ctx, data, RenderOptions{}); !errors.Is(err, context.Canceled) {
+			t.Errorf("err = %v, want context.Canceled", err)
+		}
+		// The loop stops before its second iteration
+		if len(seen) != 1 {
+			t.Errorf("rendered items %v, want only the first", seen)
+		}
+	})
+}
+
+func TestLimits(t *testing.T) {
+	img, err := os.ReadFile("testdata/test_image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	size := int64(len(img))
+	data := map[string]interface{}{"Items": []int{1, 2, 3}, "Image": drawingInjector(img)}
+	loop := []string{"{{for v in Items}}", "{{ v }}", "{{endfor}}"}
+	images := []string{"{{ inject .Image }}", "{{ inject .Image }}"}
+	tests := []struct {
+		name   string
+		lines  []string
+		limits Limits
+		// limit is the Limits field exceeded, "" if the render succeeds
+		limit string
+	}{
+		{"iterations", loop, Limits{MaxIterations: 2}, "MaxIterations"},
+		{"iterations within", loop, Limits{MaxIterations: 3}, ""},
+		{"paragraphs", []string{"a", "b", "c"}, Limits{MaxParagraphs: 2}, "MaxParagraphs"},
+		{"paragraphs within", []string{"a", "b", "c"}, Limits{MaxParagraphs: 3}, ""},
+		{"depth", loop, Limits{MaxDepth: 1}, "MaxDepth"},
+		{"depth within", loop, Limits{MaxDepth: 2}, ""},
+		{"image bytes", images, Limits{MaxImageBytes: 2*size - 1}, "MaxImageBytes"},
+		{"image bytes within", images, Limits{MaxImageBytes: 2 * size}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := textTemplate(t, tt.lines...).RenderWithOptions(data, RenderOptions{Limits: tt.limits})
+			if tt.limit == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+			var le *LimitError
+			if !errors.As(err, &le) || le.Limit != tt.limit {
+				t.Errorf("err = %v, want a %s LimitError", err, tt.limit)
+			}
+		})
+	}
+}
+
+type contextKey struct{}
+
+// contextInjector returns a paragraph holding the value of contextKey in the
+// context it is given
+type contextInjector struct{}
+
+func (contextInjector) Inject(*docx.Docx, *docx.Paragraph) ([]interface{}, error) {
+	return nil, errors.New("injected without a context")
+}
+
+func (contextInjector) InjectWithContext(ctx context.Context, doc *docx.Docx, _ *docx.Paragraph) ([]interface{}, error) {
+	p := createParagraph(doc)
+	p.AddText(fmt.Sprint(ctx.Value(contextKey{})))
+	return []interface{}{p}, nil
+}
+
+func TestContextInjector(t *testing.T) {
+	tpl := textTemplate(t, "{{ inject .C }}")
+	ctx := context.WithValue(context.Background(), contextKey{}, "from ctx")
+	if err := tpl.RenderContext(ctx, map[string]interface{}{"C": contextInjector{}}, RenderOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := texts(tpl); len(got) != 1 || got[0] != "from ctx" {
+		t.Errorf("texts = %q, want the context value", got)
+	}
+}
+
+func TestHTMLImageLimit(t *testing.T) {
+	// The server sends more than the limit, then waits for the client to
+	// give up on the rest of the image
+	cut := make(chan bool, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		select {
+		case <-r.Context().Done():
+			cut <- true
+		case <-time.After(5 * time.Second):
+			cut <- false
+		}
+	}))
+	defer srv.Close()
+
+	tpl := textTemplate(t, "{{ inject .HTML }}")
+	data := map[string]interface{}{"HTML": HTMLInjector{Content: `<p><img src="` + srv.URL + `/big.png"></p>`}}
+	err := tpl.RenderWithOptions(data, RenderOptions{Limits: Limits{MaxImageBytes: 100}})
+	var le *LimitError
+	if !errors.As(err, &le) || le.Limit != "MaxImageBytes" {
+		t.Errorf("err = %v, want a MaxImageBytes LimitError", err)
+	}
+	if !<-cut {
+		t.Error("the image was downloaded past the limit")
+	}
+}
@@ -0,0 +1,202 @@
+package docxexp
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"gopkg.in/yaml.v3"
+)
+
+// InjectorDecoder returns the injector declared by value, the value of a
+// tagged object such as {"$image": value}
+type InjectorDecoder func(value interface{}) (Injector, error)
+
+// Decoder turns JSON or YAML into render data. Objects holding a single key
+// made of "$" and a registered tag, such as {"$image": {"path": "logo.png"}},
+// are decoded into injectors; the tags image, html and table are registered
+// by NewDecoder.
+type Decoder struct {
+	mu        sync.RWMutex
+	injectors map[string]InjectorDecoder
+}
+
+// NewDecoder returns a decoder with the built-in injector tags:
+//
+//	{"$image": "logo.png"} or {"$image": {"path": "logo.png", "width": 100, "height": 50}}
+//	{"$html": "<p>...</p>"}
+//	{"$table": {"header": [...], "rows": [[...]], "width": 0, "repeatHeader": true, "cantSplit": true}}
+func NewDecoder() *Decoder {
+	d := &Decoder{injectors: make(map[string]InjectorDecoder)}
+	d.Register("image", decodeImage)
+	d.Register("html", decodeHTML)
+	d.Register("table", decodeTable)
+	return d
+}
+
+// Register makes objects tagged "$"+tag decode into the injector returned by
+// decode, replacing the decoder registered for tag if any
+func (d *Decoder) Register(tag string, decode InjectorDecoder) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.injectors[strings.TrimPrefix(tag, "$")] = decode
+}
+
+// Tags returns the registered tags, sorted
+func (d *Decoder) Tags() []string {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	tags := make([]string, 0, len(d.injectors))
+	for tag := range d.injectors {
+		tags = append(tags, tag)
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// DecodeJSON reads a JSON value from r and converts it
+func (d *Decoder) DecodeJSON(r io.Reader) (interface{}, error) {
+	var v interface{}
+	if err := json.NewDecoder(r).Decode(&v); err != nil {
+		return nil, err
+	}
+	return d.Convert(v)
+}
+
+// DecodeYAML reads a YAML document from r and converts it
+func (d *Decoder) DecodeYAML(r io.Reader) (interface{}, error) {
+	var v interface{}
+	if err := yaml.NewDecoder(r).Decode(&v); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return d.Convert(v)
+}
+
+// Convert returns v, made of maps, slices and scalars, with the tagged
+// objects replaced by their injectors. YAML mappings with keys other than
+// strings get their keys formatted as strings. Objects with a single key
+// starting with "$" and an unregistered tag are an error, so typos are not
+// silently rendered as data.
+func (d *Decoder) Convert(v interface{}) (interface{}, error) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		if len(v) == 1 {
+			for k, arg := range v {
+				if strings.HasPrefix(k, "$") {
+					return d.injector(k, arg)
+				}
+			}
+		}
+		out := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			var err error
+			if out[k], err = d.Convert(elem); err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+		}
+		return out, nil
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			m[fmt.Sprint(k)] = elem
+		}
+		return d.Convert(m)
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			var err error
+			if out[i], err = d.Convert(elem); err != nil {
+				return nil, fmt.Errorf("%d: %w", i, err)
+			}
+		}
+		return out, nil
+	}
+	return v, nil
+}
+
+func (d *Decoder) injector(key string, arg interface{}) (Injector, error) {
+	d.mu.RLock()
+	decode, ok := d.injectors[strings.TrimPrefix(key, "$")]
+	d.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown injector %s", key)
+	}
+	inj, err := decode(stringKeys(arg))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", key, err)
+	}
+	return inj, nil
+}
+
+// stringKeys returns v with the keys of its YAML mappings formatted as strings
+func stringKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			out[k] = stringKeys(elem)
+		}
+		return out
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for k, elem := range v {
+			out[fmt.Sprint(k)] = stringKeys(elem)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = stringKeys(elem)
+		}
+		return out
+	}
+	return v
+}
+
+// DecodeFields decodes v, a map decoded from JSON or YAML, into the struct
+// pointed to by dst, as encoding/json does: keys match field names or json
+// tags case-insensitively. Keys without a matching field are an error.
+func DecodeFields(v interface{}, dst interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	return dec.Decode(dst)
+}
+
+func decodeImage(v interface{}) (Injector, error) {
+	if path, ok := v.(string); ok {
+		return ImageInjector{Path: path}, nil
+	}
+	var img ImageInjector
+	if err := DecodeFields(v, &img); err != nil {
+		return nil, err
+	}
+	if img.Path == "" {
+		return nil, errors.New("path is required")
+	}
+	return img, nil
+}
+
+func decodeHTML(v interface{}) (Injector, error) {
+	content, ok := v.(string)
+	if !ok {
+		return nil, fmt.Errorf("expected a string, got %T", v)
+	}
+	return HTMLInjector{Content: content}, nil
+}
+
+func decodeTable(v interface{}) (Injector, error) {
+	var tbl TableInjector
+	if err := DecodeFields(v, &tbl); err != nil {
+		return nil, err
+	}
+	return tbl, nil
+}
@@ -0,0 +1,141 @@
+package docxexp
+
+import (
+	"image"
+	_ "image/png"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// badge is a custom injector declared as {"$badge": {"label": ..., "level": ...}}
+type badge struct {
+	Label string
+	Level int `json:"level"`
+}
+
+func (badge) Inject(*docx.Docx, *docx.Paragraph) ([]interface{}, error) { return nil, nil }
+
+func TestDecoder(t *testing.T) {
+	dec := NewDecoder()
+	dec.Register("$badge", func(v interface{}) (Injector, error) {
+		var b badge
+		err := DecodeFields(v, &b)
+		return b, err
+	})
+	if got, want := dec.Tags(), []string{"badge", "html", "image", "table"}; !slices.Equal(got, want) {
+		t.Errorf("tags = %q, want %q", got, want)
+	}
+
+	tests := []struct {
+		name, json string
+		want       interface{}
+		// err is a part of the expected error message
+		err string
+	}{
+		{"image path", `{"$image": "logo.png"}`, ImageInjector{Path: "logo.png"}, ""},
+		{"image", `{"$image": {"path": "logo.png", "width": 100, "height": 50}}`, ImageInjector{Path: "logo.png", Width: 100, Height: 50}, ""},
+		{"image without path", `{"$image": {"width": 100}}`, nil, "path is required"},
+		{"image field", `{"$image": {"path": "logo.png", "size": 1}}`, nil, `unknown field "size"`},
+		{"html", `{"$html": "<p>a</p>"}`, HTMLInjector{Content: "<p>a</p>"}, ""},
+		{"html object", `{"$html": {}}`, nil, "expected a string"},
+		{"table", `{"$table": {"header": ["h"], "rows": [["a"]], "repeatHeader": true}}`,
+			TableInjector{Header: []string{"h"}, Rows: [][]string{{"a"}}, RepeatHeader: true}, ""},
+		{"custom", `{"$badge": {"label": "new", "level": 2}}`, badge{Label: "new", Level: 2}, ""},
+		{"nested", `{"a": [{"$badge": {"label": "x"}}]}`,
+			map[string]interface{}{"a": []interface{}{badge{Label: "x"}}}, ""},
+		{"unknown tag", `{"a": {"$imgae": "logo.png"}}`, nil, "a: unknown injector $imgae"},
+		{"data", `{"$a": 1, "b": 2}`, map[string]interface{}{"$a": 1.0, "b": 2.0}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := dec.DecodeJSON(strings.NewReader(tt.json))
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDecodeYAML(t *testing.T) {
+	got, err := NewDecoder().DecodeYAML(strings.NewReader("logo:\n  $image:\n    path: logo.png\n    width: 10\n1: one\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"logo": ImageInjector{Path: "logo.png", Width: 10}, "1": "one"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %#v, want %#v", got, want)
+	}
+}
+
+func TestDecodeFields(t *testing.T) {
+	var b badge
+	if err := DecodeFields(map[string]interface{}{"LABEL": "x", "level": 3}, &b); err != nil {
+		t.Fatal(err)
+	}
+	if b != (badge{Label: "x", Level: 3}) {
+		t.Errorf("decoded %+v", b)
+	}
+	if err := DecodeFields(map[string]interface{}{"color": "red"}, &b); err == nil {
+		t.Error("decoded an unknown field")
+	}
+	if err := DecodeFields(map[string]interface{}{"level": "high"}, &b); err == nil {
+		t.Error("decoded a string into an int")
+	}
+}
+
+var extentRe = regexp.MustCompile(`<wp:extent cx="(\d+)" cy="(\d+)"`)
+
+func TestImageSize(t *testing.T) {
+	f, err := os.Open("testdata/test_image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := "testdata/test_image.png"
+	tpl := textTemplate(t, "{{ inject .Wide }}", "{{ inject .Tall }}", "{{ inject .Both }}")
+	data := map[string]interface{}{
+		"Wide": ImageInjector{Path: path, Width: 200},
+		"Tall": ImageInjector{Path: path, Height: 50},
+		"Both": ImageInjector{Path: path, Width: 30, Height: 40},
+	}
+	if err := tpl.Render(data); err != nil {
+		t.Fatal(err)
+	}
+	var got [][2]int64
+	for _, m := range extentRe.FindAllStringSubmatch(savedPart(t, tpl, documentPart), -1) {
+		cx, _ := strconv.ParseInt(m[1], 10, 64)
+		cy, _ := strconv.ParseInt(m[2], 10, 64)
+		got = append(got, [2]int64{cx, cy})
+	}
+	// The side left out keeps the aspect ratio, give or take rounding
+	w, h := int64(cfg.Width), int64(cfg.Height)
+	want := [][2]int64{
+		{200 * emuPerPixel, 200 * emuPerPixel * h / w},
+		{50 * emuPerPixel * w / h, 50 * emuPerPixel},
+		{30 * emuPerPixel, 40 * emuPerPixel},
+	}
+	near := func(a, b [2]int64) bool { return max(a[0]-b[0], b[0]-a[0]) <= 1 && max(a[1]-b[1], b[1]-a[1]) <= 1 }
+	if !slices.EqualFunc(got, want, near) {
+		t.Errorf("extents = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,164 @@
+package docxexp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// delims holds the delimiters of a template and the tag patterns built from them
+type delims struct {
+	// left and right delimit inline text/template actions
+	left, right string
+	// blockLeft and blockRight delimit block, row, tableif and layout tags
+	blockLeft, blockRight string
+
+	tableIf   *regexp.Regexp
+	layout    *regexp.Regexp
+	inlineEnd *regexp.Regexp
+	comment   *regexp.Regexp
+	anyTag    *regexp.Regexp
+
+	// escaper hides escaped delimiters such as \{{ behind placeholders
+	// and unescaper turns the placeholders into literal delimiters
+	escaper, unescaper *strings.Replacer
+}
+
+var defaultDelims = newDelims("{{", "}}", "{{", "}}")
+
+func newDelims(left, right, blockLeft, blockRight string) *delims {
+	l, r := regexp.QuoteMeta(left), regexp.QuoteMeta(right)
+	bl, br := regexp.QuoteMeta(blockLeft), regexp.QuoteMeta(blockRight)
+	d := &delims{
+		left:       left,
+		right:      right,
+		blockLeft:  blockLeft,
+		blockRight: blockRight,
+		tableIf:    regexp.MustCompile(bl + `-?\s*tableif\s+(.*?)\s*-?` + br),
+		layout:     regexp.MustCompile(bl + `-?\s*(repeatheader|cantsplit|keepnext|keeplines|caption)\s*-?` + br),
+		inlineEnd:  regexp.MustCompile(l + `-?\s*end\s*-?` + r),
+		comment:    regexp.MustCompile(l + `#[\s\S]*?` + r + `|` + bl + `#[\s\S]*?` + br),
+		anyTag:     regexp.MustCompile(l + `[\s\S]*?` + r + `|` + bl + `[\s\S]*?` + br),
+	}
+
+	var escapes, unescapes []string
+	seen := make(map[string]bool)
+	for _, delim := range []string{left, right, blockLeft, blockRight} {
+		if seen[delim] {
+			continue
+		}
+		seen[delim] = true
+		mark := fmt.Sprintf("__DELIM_%d__", len(seen))
+		escapes = append(escapes, `\`+delim, mark)
+		unescapes = append(unescapes, mark, delim)
+	}
+	d.escaper = strings.NewReplacer(escapes...)
+	d.unescaper = strings.NewReplacer(unescapes...)
+	return d
+}
+
+// Delims sets the delimiters of inline actions and, unless BlockDelims has
+// been called, of block tags. An empty delimiter selects the default "{{" or "}}".
+func (t *DocxTemplate) Delims(left, right string) {
+	left, right = orDefault(left, "{{"), orDefault(right, "}}")
+	blockLeft, blockRight := t.delims.blockLeft, t.delims.blockRight
+	if !t.delims.split() {
+		blockLeft, blockRight = left, right
+	}
+	t.delims = newDelims(left, right, blockLeft, blockRight)
+}
+
+// BlockDelims sets the delimiters of the block, row, tableif and layout tags,
+// such as "{%" and "%}" for docxtpl-style templates. Inside block tags with
+// their own delimiters, spaces around the tag content are ignored.
+func (t *DocxTemplate) BlockDelims(left, right string) {
+	left, right = orDefault(left, t.delims.left), orDefault(right, t.delims.right)
+	t.delims = newDelims(t.delims.left, t.delims.right, left, right)
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
+
+// split reports whether block tags have delimiters of their own
+func (d *delims) split() bool {
+	return d.blockLeft != d.left || d.blockRight != d.right
+}
+
+// blockTag returns the content of text when it is a single block tag
+func (d *delims) blockTag(text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if len(text) < len(d.blockLeft)+len(d.blockRight) ||
+		!strings.HasPrefix(text, d.blockLeft) || !strings.HasSuffix(text, d.blockRight) {
+		return "", false
+	}
+	content := text[len(d.blockLeft) : len(text)-len(d.blockRight)]
+	// Text such as {{if .A}}a{{end}} is made of inline actions
+	if strings.Contains(content, d.blockLeft) || strings.Contains(content, d.blockRight) {
+		return "", false
+	}
+	if d.split() {
+		content = strings.TrimSpace(content)
+	}
+	return content, true
+}
+
+// startsBlock reports whether text starts with a block tag for keyword, such
+// as {{for or {{if
+func (d *delims) startsBlock(text, keyword string) bool {
+	text = strings.TrimSpace(text)
+	if !strings.HasPrefix(text, d.blockLeft) {
+		return false
+	}
+	rest := text[len(d.blockLeft):]
+	if d.split() {
+		rest = strings.TrimLeft(rest, " ")
+	}
+	return strings.HasPrefix(rest, keyword+" ")
+}
+
+// tag returns the block tag for content, such as {{endfor}}
+func (d *delims) tag(content string) string {
+	return d.blockLeft + content + d.blockRight
+}
+
+// hasTags reports whether text holds tags, escaped delimiters or comments
+func (d *delims) hasTags(text string) bool {
+	return strings.Contains(text, d.left) || strings.Contains(text, d.blockLeft) ||
+		strings.Contains(text, `\`+d.right) || strings.Contains(text, `\`+d.blockRight)
+}
+
+// findTags returns the tags of text, comments included, skipping escaped
+// delimiters
+func (d *delims) findTags(text string) []string {
+	tags := d.anyTag.FindAllString(d.escape(text), -1)
+	for i, tag := range tags {
+		tags[i] = d.unescape(tag)
+	}
+	return tags
+}
+
+// escape hides the escaped delimiters of text, such as \{{ and \}}, from
+// tag parsing
+func (d *delims) escape(text string) string {
+	return d.escaper.Replace(text)
+}
+
+// unescape turns the delimiters hidden by escape into literal delimiters
+func (d *delims) unescape(text string) string {
+	return d.unescaper.Replace(text)
+}
+
+// stripComments removes the {{# comment }} tags from text
+func (d *delims) stripComments(text string) string {
+	return d.comment.ReplaceAllString(text, "")
+}
+
+// commentOnly reports whether text holds comment tags and nothing else
+func (d *delims) commentOnly(text string) bool {
+	text = d.escape(text)
+	return d.comment.MatchString(text) && strings.TrimSpace(d.stripComments(text)) == ""
+}
@@ -0,0 +1,88 @@
+package docxexp
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestDelims(t *testing.T) {
+	data := map[string]interface{}{"Name": "Ann", "Items": []string{"a", "b"}, "Show": true}
+	tests := []struct {
+		name          string
+		left, right   string
+		bleft, bright string
+		lines         []string
+		want          []string
+	}{
+		{
+			name: "default", lines: []string{"{{ .Name }}", "{{for v in Items}}", "{{ v }}", "{{endfor}}"},
+			want: []string{"Ann", "a", "b"},
+		},
+		{
+			name: "inline blocks only", lines: []string{"{{if .Show}}yes{{else}}no{{end}}", "{{range .Items}}{{.}}{{end}}"},
+			want: []string{"yes", "ab"},
+		},
+		{
+			name: "inline", left: "[[", right: "]]",
+			lines: []string{"[[ .Name ]] {{ .Name }}", "[[for v in Items]]", "[[ v ]]", "[[endfor]]"},
+			want:  []string{"Ann {{ .Name }}", "a", "b"},
+		},
+		{
+			name: "block", bleft: "{%", bright: "%}",
+			lines: []string{"{% if Show %}", "{{ .Name }}", "{% endif %}", "{% for v in Items %}", "{{ v }}", "{% endfor %}"},
+			want:  []string{"Ann", "a", "b"},
+		},
+		{
+			name: "both", left: "<<", right: ">>", bleft: "<%", bright: "%>",
+			lines: []string{"<% for v in Items %>", "<< v >> {{ v }}", "<% endfor %>"},
+			want:  []string{"a {{ v }}", "b {{ v }}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			if tt.left != "" {
+				tpl.Delims(tt.left, tt.right)
+			}
+			if tt.bleft != "" {
+				tpl.BlockDelims(tt.bleft, tt.bright)
+			}
+			if err := tpl.Render(data); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEscapesAndComments(t *testing.T) {
+	data := map[string]interface{}{"Name": "Ann"}
+	tests := []struct {
+		name        string
+		left, right string
+		lines       []string
+		want        []string
+	}{
+		{"escape", "", "", []string{`\{{ .Name \}} is {{ .Name }}`}, []string{"{{ .Name }} is Ann"}},
+		{"escape only", "", "", []string{`\{{ not a tag \}}`}, []string{"{{ not a tag }}"}},
+		{"comment", "", "", []string{"{{ .Name }}{{# note }}!"}, []string{"Ann!"}},
+		{"comment paragraph", "", "", []string{"{{# note }}", "{{# one }} {{# two }}", "{{ .Name }}"}, []string{"Ann"}},
+		{"custom escape", "[[", "]]", []string{`\[[ .Name \]] [[ .Name ]] [[# note ]]`}, []string{"[[ .Name ]] Ann "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			if tt.left != "" {
+				tpl.Delims(tt.left, tt.right)
+			}
+			if err := tpl.Render(data); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
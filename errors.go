@@ -0,0 +1,169 @@
+package docxexp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// RenderError is returned by Render. It records where in the template the
+// underlying error happened. Use errors.As to get the RenderError or the
+// error kind it wraps: *FieldError, *BlockError, *TemplateError or *InjectError.
+type RenderError struct {
+	Location Location
+	// Loops are the loop iterations being rendered, outermost first
+	Loops []LoopFrame
+	// Expr is the expression or tag being evaluated
+	Expr string
+	Err  error
+}
+
+// LoopFrame is one iteration of a {{for}} block or of a table row {{ range }}
+type LoopFrame struct {
+	// Variable is the loop variable of a {{for}} block
+	Variable string
+	// Source is the expression of the loop
+	Source string
+	Index  int
+}
+
+func (e *RenderError) Error() string {
+	var sb strings.Builder
+	sb.WriteString(e.Location.String())
+	for _, l := range e.Loops {
+		fmt.Fprintf(&sb, " [%s #%d]", l.Source, l.Index)
+	}
+	if e.Expr != "" {
+		fmt.Fprintf(&sb, " %q", e.Expr)
+	}
+	sb.WriteString(": ")
+	sb.WriteString(e.Err.Error())
+	return sb.String()
+}
+
+func (e *RenderError) Unwrap() error {
+	return e.Err
+}
+
+// FieldError reports a field or key missing from the data
+type FieldError struct {
+	Field string
+	// inMap is set when the field was looked up in a map
+	inMap bool
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("field %s not found", e.Field)
+}
+
+// BlockError reports a block tag without its end tag, or a malformed tag
+type BlockError struct {
+	Tag string
+	Msg string
+}
+
+func (e *BlockError) Error() string {
+	return e.Msg
+}
+
+// TemplateError reports an inline text/template action that failed to
+// parse or execute
+type TemplateError struct {
+	Err error
+}
+
+func (e *TemplateError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *TemplateError) Unwrap() error {
+	return e.Err
+}
+
+// InjectError reports an injector that failed
+type InjectError struct {
+	// Injector is the Injector or InjectorV2 passed to inject
+	Injector interface{}
+	Err      error
+}
+
+func (e *InjectError) Error() string {
+	return fmt.Sprintf("inject %T: %v", e.Injector, e.Err)
+}
+
+func (e *InjectError) Unwrap() error {
+	return e.Err
+}
+
+// RenderErrors is returned by a render with RenderOptions.CollectErrors set
+// when one or more tags failed. The partially rendered document can still be saved.
+type RenderErrors []*RenderError
+
+func (e RenderErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+func (e RenderErrors) Unwrap() []error {
+	errs := make([]error, len(e))
+	for i, err := range e {
+		errs[i] = err
+	}
+	return errs
+}
+
+// collect records err when errors are collected and marks p, if any, with
+// its message. It reports whether rendering should go on, which it never
+// does past cancellation or a render limit.
+func (t *DocxTemplate) collect(err error, expr string, p *docx.Paragraph) bool {
+	if !t.opts.CollectErrors || aborts(err) {
+		return false
+	}
+	var re *RenderError
+	if !errors.As(t.newError(err, expr), &re) {
+		return false
+	}
+	t.errs = append(t.errs, re)
+	if p != nil {
+		markError(p, re.Err)
+	}
+	return true
+}
+
+// markError appends a red highlighted run holding the message of err to p
+func markError(p *docx.Paragraph, err error) {
+	p.Children = append(p.Children, &docx.Run{
+		RunProperties: &docx.RunProperties{
+			Color:     &docx.Color{Val: "FF0000"},
+			Highlight: &docx.Highlight{Val: "yellow"},
+		},
+		Children: []interface{}{&docx.Text{Text: " [error: " + err.Error() + "]", XMLSpace: "preserve"}},
+	})
+}
+
+// newError wraps err in a RenderError located at the paragraph being
+// rendered. Errors that already carry a location are returned unchanged.
+func (t *DocxTemplate) newError(err error, expr string) error {
+	var re *RenderError
+	if errors.As(err, &re) {
+		return err
+	}
+	return &RenderError{
+		Location: t.location(),
+		Loops:    append([]LoopFrame(nil), t.loops...),
+		Expr:     expr,
+		Err:      err,
+	}
+}
+
+// location returns a copy of the location being rendered
+func (t *DocxTemplate) location() Location {
+	loc := t.loc
+	loc.Cells = append([]CellRef(nil), t.loc.Cells...)
+	return loc
+}
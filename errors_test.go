@@ -0,0 +1,143 @@
+package docxexp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+func TestCollectErrors(t *testing.T) {
+	data := map[string]interface{}{"Name": "Ann", "Items": []string{"a", "b"}}
+	tests := []struct {
+		name  string
+		lines []string
+		// want are the texts rendered, errors are marked with [error: ...]
+		want []string
+		errs int
+	}{
+		{"none", []string{"{{ .Name }}"}, []string{"Ann"}, 0},
+		{"inline", []string{"{{ .Name | nope }}", "{{ .Name }}"}, []string{"{{ .Name | nope }} [error:", "Ann"}, 1},
+		{"missing block", []string{"{{if Nope}}", "x", "{{endif}}", "{{ .Name }}"}, []string{"{{if Nope}} [error:", "x", "{{endif}}", "Ann"}, 1},
+		{"unclosed block", []string{"{{for v in Items}}", "{{ v }}"}, []string{"{{for v in Items}} [error:", "{{ v }} [error:"}, 2},
+		{"in loop", []string{"{{for v in Items}}", "{{ v | nope }}", "{{endfor}}"}, []string{"{{ v | nope }} [error:", "{{ v | nope }} [error:"}, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			err := tpl.RenderWithOptions(data, RenderOptions{CollectErrors: true})
+			var errs RenderErrors
+			if tt.errs == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else if !errors.As(err, &errs) || len(errs) != tt.errs {
+				t.Fatalf("err = %v, want %d RenderErrors", err, tt.errs)
+			}
+			got := texts(tpl)
+			if len(got) != len(tt.want) {
+				t.Fatalf("texts = %q, want %q", got, tt.want)
+			}
+			for i, want := range tt.want {
+				if marked := strings.HasSuffix(want, "[error:"); marked && !strings.HasPrefix(got[i], want) ||
+					!marked && got[i] != want {
+					t.Errorf("text %d = %q, want %q", i, got[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCollectErrorsLocation(t *testing.T) {
+	tpl := textTemplate(t, "ok", "{{for v in Items}}", "{{ v.Nope }}", "{{endfor}}")
+	err := tpl.RenderWithOptions(map[string]interface{}{"Items": []map[string]string{{}}}, RenderOptions{
+		CollectErrors: true,
+		MissingKey:    MissingKeyError,
+	})
+	var errs RenderErrors
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("err = %v, want one RenderError", err)
+	}
+	re := errs[0]
+	var fe *FieldError
+	if !errors.As(re, &fe) {
+		t.Errorf("err = %v, want a FieldError", re)
+	}
+	if re.Location.Paragraph != 2 || len(re.Loops) != 1 || re.Loops[0].Source != "Items" {
+		t.Errorf("location = %v %v, want paragraph 2 in the Items loop", re.Location, re.Loops)
+	}
+	if got := texts(tpl); len(got) != 2 || !strings.Contains(got[1], "[error: ") {
+		t.Errorf("texts = %q, want the field marked", got)
+	}
+}
+
+func TestRenderErrorLocation(t *testing.T) {
+	items := []map[string]string{{"Nope": "x"}, {}}
+	lines := func(lines ...string) func(doc *docx.Docx) {
+		return func(doc *docx.Docx) {
+			for _, line := range lines {
+				doc.AddParagraph().AddText(line)
+			}
+		}
+	}
+	tests := []struct {
+		name  string
+		fill  func(doc *docx.Docx)
+		loc   Location
+		loops []LoopFrame
+	}{
+		{"paragraph", lines("a", "{{ .Nope }}"), Location{Item: 1, Paragraph: 1}, nil},
+		{"for", lines("a", "{{for v in Items}}", "b", "{{ v.Nope }}", "{{endfor}}"),
+			Location{Item: 3, Paragraph: 3}, []LoopFrame{{Variable: "v", Source: "Items", Index: 1}}},
+		{"nested for", lines("{{for g in Groups}}", "{{for w in g.Items}}", "{{ w.Nope }}", "{{endfor}}", "{{endfor}}"),
+			Location{Item: 2, Paragraph: 2}, []LoopFrame{{"g", "Groups", 0}, {"w", "g.Items", 1}}},
+		// The location of a macro is that of its {{define}} body
+		{"macro", lines("{{define box}}", "b", "{{ .Nope }}", "{{enddefine}}", "c", "{{call box .Item}}"),
+			Location{Item: 2, Paragraph: 2}, nil},
+		{"macro in a for", lines("{{define box}}", "{{ .Nope }}", "{{enddefine}}", "{{for v in Items}}", "{{call box v}}", "{{endfor}}"),
+			Location{Item: 1, Paragraph: 1}, []LoopFrame{{"v", "Items", 1}}},
+		{"row range", func(doc *docx.Docx) {
+			doc.AddParagraph().AddText("a")
+			tbl := doc.AddTable(2, 2, 0, nil)
+			tbl.TableRows[0].TableCells[0].AddParagraph().AddText("H")
+			tbl.TableRows[1].TableCells[0].AddParagraph().AddText("{{ range .Items }}x")
+			cell := tbl.TableRows[1].TableCells[1]
+			cell.AddParagraph().AddText("y")
+			cell.AddParagraph().AddText("{{ .Nope }}")
+		}, Location{Item: 1, Cells: []CellRef{{Table: 1, Row: 1, Cell: 1}}, Paragraph: 1}, []LoopFrame{{Source: ".Items", Index: 1}}},
+		{"for in a cell", func(doc *docx.Docx) {
+			tbl := doc.AddTable(1, 1, 0, nil)
+			cell := tbl.TableRows[0].TableCells[0]
+			for _, line := range []string{"{{for v in Items}}", "{{ v.Nope }}", "{{endfor}}"} {
+				cell.AddParagraph().AddText(line)
+			}
+		}, Location{Cells: []CellRef{{}}, Paragraph: 1}, []LoopFrame{{"v", "Items", 1}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := newTestTemplate(t, tt.fill)
+			err := tpl.RenderWithOptions(map[string]interface{}{
+				"Items":  items,
+				"Groups": []map[string]interface{}{{"Items": items}},
+				"Item":   map[string]string{},
+			},
+				RenderOptions{MissingKey: MissingKeyError})
+			var re *RenderError
+			if !errors.As(err, &re) {
+				t.Fatalf("err = %v, want a RenderError", err)
+			}
+			var fe *FieldError
+			if !errors.As(err, &fe) || fe.Field != "Nope" {
+				t.Errorf("err = %v, want a FieldError for Nope", err)
+			}
+			loc := re.Location
+			loc.Text = ""
+			tt.loc.Part = documentPart
+			if !reflect.DeepEqual(loc, tt.loc) || !reflect.DeepEqual(re.Loops, tt.loops) {
+				t.Errorf("at %v %v, want %v %v", loc, re.Loops, tt.loc, tt.loops)
+			}
+		})
+	}
+}
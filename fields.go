@@ -0,0 +1,224 @@
+package docxexp
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// structField is an exported field of a struct, promoted fields included
+type structField struct {
+	index []int
+	name  string
+	// tag is the name given by the docx or json tag, empty if none
+	tag       string
+	hidden    bool
+	omitEmpty bool
+}
+
+// fieldCache holds the []structField of each struct type
+var fieldCache sync.Map
+
+// structFields returns the fields of the struct type typ
+func structFields(typ reflect.Type) []structField {
+	if fields, ok := fieldCache.Load(typ); ok {
+		return fields.([]structField)
+	}
+	var fields []structField
+	for _, f := range reflect.VisibleFields(typ) {
+		if !f.IsExported() {
+			continue
+		}
+		sf := structField{index: f.Index, name: f.Name}
+		tag, ok := f.Tag.Lookup("docx")
+		if !ok {
+			tag = f.Tag.Get("json")
+		}
+		// As with encoding/json, "-" hides the field and "-," names it -
+		name, opts, _ := strings.Cut(tag, ",")
+		sf.hidden = tag == "-"
+		if !sf.hidden {
+			sf.tag = name
+		}
+		for _, opt := range strings.Split(opts, ",") {
+			sf.omitEmpty = sf.omitEmpty || opt == "omitempty"
+		}
+		fields = append(fields, sf)
+	}
+	fieldCache.Store(typ, fields)
+	return fields
+}
+
+// fieldOptions reports whether a RenderOptions field changes how fields are
+// resolved
+func (o RenderOptions) fieldOptions() bool {
+	return o.FieldTags || o.IgnoreCase || o.OmitEmpty
+}
+
+// lookupPath resolves the dotted path, such as .Project.Name, from data.
+// Struct fields are matched as RenderOptions.FieldTags, IgnoreCase and
+// OmitEmpty select, and methods without arguments are called.
+func (t *DocxTemplate) lookupPath(data interface{}, path string) (interface{}, error) {
+	val, _, err := t.lookup(data, path)
+	return val, err
+}
+
+// lookup is lookupPath, also reporting whether the value is the empty value
+// of a field tagged omitempty with RenderOptions.OmitEmpty. Such a value ends
+// the path: the fields after it resolve to nil.
+func (t *DocxTemplate) lookup(data interface{}, path string) (interface{}, bool, error) {
+	parts := strings.Split(strings.TrimPrefix(path, "."), ".")
+	val := reflect.ValueOf(data)
+
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if m, ok, err := method(val, part); ok {
+			if err != nil {
+				return nil, false, err
+			}
+			val = m
+			continue
+		}
+		for val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface {
+			val = val.Elem()
+		}
+		inMap := false
+		if val.Kind() == reflect.Struct {
+			var omitted bool
+			if val, omitted = t.field(val, part); omitted {
+				if i < len(parts)-1 {
+					return nil, true, nil
+				}
+				return val.Interface(), true, nil
+			}
+		} else if val.Kind() == reflect.Map {
+			inMap = true
+			val = t.mapIndex(val, part)
+		} else {
+			val = reflect.Value{}
+		}
+		if !val.IsValid() || !val.CanInterface() {
+			return nil, false, &FieldError{Field: part, inMap: inMap}
+		}
+	}
+
+	if !val.IsValid() {
+		return nil, false, &FieldError{Field: path}
+	}
+	return val.Interface(), false, nil
+}
+
+// field returns the field of the struct val named name, or the zero Value,
+// and whether it is an empty field omitted with RenderOptions.OmitEmpty
+func (t *DocxTemplate) field(val reflect.Value, name string) (reflect.Value, bool) {
+	if !t.opts.fieldOptions() {
+		return val.FieldByName(name), false
+	}
+	fields := structFields(val.Type())
+	match := func(eq func(a, b string) bool) *structField {
+		if t.opts.FieldTags {
+			for i := range fields {
+				if fields[i].tag != "" && eq(fields[i].tag, name) {
+					return &fields[i]
+				}
+			}
+		}
+		for i := range fields {
+			if !(t.opts.FieldTags && fields[i].hidden) && eq(fields[i].name, name) {
+				return &fields[i]
+			}
+		}
+		return nil
+	}
+	f := match(func(a, b string) bool { return a == b })
+	if f == nil && t.opts.IgnoreCase {
+		f = match(strings.EqualFold)
+	}
+	if f == nil {
+		return reflect.Value{}, false
+	}
+	fv, err := val.FieldByIndexErr(f.index)
+	if err != nil {
+		return reflect.Value{}, false
+	}
+	return fv, t.opts.OmitEmpty && f.omitEmpty && isEmptyValue(fv)
+}
+
+// mapIndex returns the element of the map val with the key name, or the zero
+// Value. With IgnoreCase the first key in sorted order equal to name under
+// case folding is used when no key is equal to name.
+func (t *DocxTemplate) mapIndex(val reflect.Value, name string) reflect.Value {
+	keyType := val.Type().Key()
+	if keyType.Kind() != reflect.String && keyType.Kind() != reflect.Interface {
+		return reflect.Value{}
+	}
+	key := reflect.ValueOf(name)
+	if keyType.Kind() == reflect.String {
+		key = key.Convert(keyType)
+	}
+	if v := val.MapIndex(key); v.IsValid() || !t.opts.IgnoreCase {
+		return v
+	}
+	var match reflect.Value
+	var matchName string
+	for _, k := range val.MapKeys() {
+		s, ok := k.Interface().(string)
+		if k.Kind() == reflect.String {
+			s, ok = k.String(), true
+		}
+		if ok && strings.EqualFold(s, name) && (!match.IsValid() || s < matchName) {
+			match, matchName = k, s
+		}
+	}
+	if !match.IsValid() {
+		return reflect.Value{}
+	}
+	return val.MapIndex(match)
+}
+
+// method calls the method name of val when it takes no arguments and returns
+// a value, or a value and an error
+func method(val reflect.Value, name string) (reflect.Value, bool, error) {
+	if !val.IsValid() {
+		return reflect.Value{}, false, nil
+	}
+	if val.Kind() == reflect.Interface && !val.IsNil() {
+		val = val.Elem()
+	}
+	m := val.MethodByName(name)
+	if !m.IsValid() && val.Kind() != reflect.Ptr && val.CanAddr() {
+		m = val.Addr().MethodByName(name)
+	}
+	if !m.IsValid() {
+		return reflect.Value{}, false, nil
+	}
+	mt := m.Type()
+	errorType := reflect.TypeOf((*error)(nil)).Elem()
+	if mt.NumIn() != 0 || mt.NumOut() == 0 || mt.NumOut() > 2 ||
+		(mt.NumOut() == 2 && mt.Out(1) != errorType) {
+		return reflect.Value{}, false, nil
+	}
+	out := m.Call(nil)
+	if len(out) == 2 && !out[1].IsNil() {
+		return reflect.Value{}, true, out[1].Interface().(error)
+	}
+	return out[0], true, nil
+}
+
+// isEmptyValue reports whether v is empty as encoding/json defines it for
+// omitempty
+func isEmptyValue(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Array, reflect.Map, reflect.Slice, reflect.String:
+		return v.Len() == 0
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64,
+		reflect.Interface, reflect.Pointer:
+		return v.IsZero()
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package docxexp
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+type testMeta struct {
+	Created string `json:"created"`
+}
+
+type testFinding struct {
+	testMeta
+	Title    string     `json:"title"`
+	CVSS     string     `docx:"cvss_score" json:"cvss,omitempty"`
+	Internal string     `json:"-"`
+	Dash     string     `json:"-,"`
+	Owner    *testOwner `json:"owner,omitempty"`
+	Tags     []string   `json:"tags,omitempty"`
+	secret   string
+}
+
+type testOwner struct {
+	Name string `json:"name"`
+}
+
+func (f testFinding) Upper() string { return "UP " + f.Title }
+
+func TestLookupPath(t *testing.T) {
+	finding := testFinding{
+		testMeta: testMeta{Created: "today"},
+		Title:    "XSS",
+		CVSS:     "6.1",
+		Internal: "hidden",
+		Dash:     "dash",
+		secret:   "s",
+	}
+	tags := RenderOptions{FieldTags: true}
+	tests := []struct {
+		name string
+		opts RenderOptions
+		data interface{}
+		path string
+		want interface{}
+		// missing is set when the lookup fails with a FieldError
+		missing bool
+	}{
+		{"go name", RenderOptions{}, finding, ".Title", "XSS", false},
+		{"tag without FieldTags", RenderOptions{}, finding, ".title", nil, true},
+		{"json tag", tags, finding, ".title", "XSS", false},
+		{"docx tag first", tags, finding, ".cvss_score", "6.1", false},
+		{"json tag shadowed", tags, finding, ".cvss", nil, true},
+		{"go name with tags", tags, finding, ".Title", "XSS", false},
+		{"hidden", tags, finding, ".Internal", nil, true},
+		{"dash name", tags, finding, ".-", "dash", false},
+		{"promoted", tags, finding, ".created", "today", false},
+		{"method", RenderOptions{}, finding, ".Upper", "UP XSS", false},
+		{"unexported", RenderOptions{}, finding, ".secret", nil, true},
+		{"pointer", tags, &finding, ".title", "XSS", false},
+		{"nil pointer", tags, finding, ".owner.name", nil, true},
+		{"ignore case field", RenderOptions{IgnoreCase: true}, finding, ".TITLE", "XSS", false},
+		{"ignore case tag", RenderOptions{IgnoreCase: true, FieldTags: true}, finding, ".CVSS_Score", "6.1", false},
+		{"ignore case map", RenderOptions{IgnoreCase: true}, map[string]string{"Name": "Ann"}, ".name", "Ann", false},
+		{"exact map key first", RenderOptions{IgnoreCase: true}, map[string]string{"name": "a", "Name": "b"}, ".Name", "b", false},
+		{"omitempty", RenderOptions{FieldTags: true, OmitEmpty: true}, finding, ".owner.name", nil, false},
+		{"nil root", RenderOptions{}, nil, ".Title", nil, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := &DocxTemplate{opts: tt.opts}
+			got, err := tpl.lookupPath(tt.data, tt.path)
+			if tt.missing {
+				var fe *FieldError
+				if !errors.As(err, &fe) {
+					t.Fatalf("lookupPath = %v, %v, want a FieldError", got, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("lookupPath = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFieldOptionsRender(t *testing.T) {
+	finding := testFinding{Title: "XSS"}
+	tests := []struct {
+		name  string
+		opts  RenderOptions
+		lines []string
+		want  []string
+	}{
+		{"inline tags", RenderOptions{FieldTags: true}, []string{"{{ .title }}"}, []string{"XSS"}},
+		{"block tags", RenderOptions{FieldTags: true}, []string{"{{if title}}", "yes", "{{endif}}"}, []string{"yes"}},
+		{"omitempty inline", RenderOptions{FieldTags: true, OmitEmpty: true}, []string{"[{{ .cvss_score }}]", "[{{ .owner.name }}]"}, []string{"[]", "[]"}},
+		{"omitempty condition", RenderOptions{FieldTags: true, OmitEmpty: true}, []string{"{{if cvss_score}}", "scored", "{{endif}}", "{{if tags}}", "tagged", "{{endif}}", "end"}, []string{"end"}},
+		{"ignore case", RenderOptions{IgnoreCase: true}, []string{"{{ .title }}"}, []string{"XSS"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			if err := tpl.RenderWithOptions(finding, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package docxexp
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
 	"io"
@@ -19,6 +20,54 @@ type HTMLInjector struct {
 
 // Inject implements the Injector interface
 func (h HTMLInjector) Inject(doc *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
+	return h.InjectWithContext(context.Background(), doc, p)
+}
+
+// InjectWithContext implements the ContextInjector interface. Remote images
+// are downloaded with ctx.
+func (h HTMLInjector) InjectWithContext(ctx context.Context, doc *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
+	return h.inject(&htmlImages{ctx: ctx, left: -1}, doc, p)
+}
+
+// InjectV2 implements the InjectorV2 interface. Images are rejected as soon
+// as they go over what Limits.MaxImageBytes leaves, before remote images are
+// fully downloaded.
+func (h HTMLInjector) InjectV2(ic *InjectContext) (Injection, error) {
+	im := &htmlImages{ctx: ic.Context, left: -1}
+	if left, ok := ic.ImageBytesLeft(); ok {
+		im.left, im.max = left, ic.Options.Limits.MaxImageBytes
+	}
+	items, err := h.inject(im, ic.Doc, ic.Paragraph)
+	if err != nil || items == nil {
+		return Injection{}, err
+	}
+	return BlockItems(items...), nil
+}
+
+// htmlImages are the settings and state of the images of an injection
+type htmlImages struct {
+	ctx context.Context
+	// left is the number of image bytes that may still be added, -1 for no
+	// limit, and max the limit it comes from
+	left, max int64
+	// err is the limit error that stopped the injection
+	err error
+}
+
+// take accounts for an image of n bytes
+func (im *htmlImages) take(n int64) error {
+	if im.left < 0 {
+		return nil
+	}
+	if n > im.left {
+		im.err = &LimitError{Limit: "MaxImageBytes", Max: im.max}
+		return im.err
+	}
+	im.left -= n
+	return nil
+}
+
+func (h HTMLInjector) inject(im *htmlImages, doc *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
 	// Parse HTML
 	node, err := html.Parse(strings.NewReader(h.Content))
 	if err != nil {
@@ -53,12 +102,12 @@ func (h HTMLInjector) Inject(doc *docx.Docx, p *docx.Paragraph) ([]interface{},
 				newP := createParagraph(doc)
 				newP.XMLName = p.XMLName
 				// Handle children (text, img, etc)
-				processChildren(doc, n, newP)
+				processChildren(im, doc, n, newP)
 				items = append(items, newP)
 			case "img":
 				newP := createParagraph(doc)
 				newP.XMLName = p.XMLName
-				if err := addImage(doc, newP, n); err == nil {
+				if err := addImage(im, doc, newP, n); err == nil {
 					items = append(items, newP)
 				}
 			case "body", "html":
@@ -87,6 +136,12 @@ func (h HTMLInjector) Inject(doc *docx.Docx, p *docx.Paragraph) ([]interface{},
 		}
 	}
 	f(node)
+	if im.err != nil {
+		return nil, im.err
+	}
+	if err := im.ctx.Err(); err != nil {
+		return nil, err
+	}
 	return items, nil
 }
 
@@ -124,7 +179,7 @@ func extractText(n *html.Node) string {
 	return text
 }
 
-func processChildren(doc *docx.Docx, n *html.Node, p *docx.Paragraph) {
+func processChildren(im *htmlImages, doc *docx.Docx, n *html.Node, p *docx.Paragraph) {
 	for c := n.FirstChild; c != nil; c = c.NextSibling {
 		if c.Type == html.TextNode {
 			run := &docx.Run{
@@ -132,14 +187,14 @@ func processChildren(doc *docx.Docx, n *html.Node, p *docx.Paragraph) {
 			}
 			p.Children = append(p.Children, run)
 		} else if c.Type == html.ElementNode && c.Data == "img" {
-			addImage(doc, p, c)
+			addImage(im, doc, p, c)
 		} else {
-			processChildren(doc, c, p)
+			processChildren(im, doc, c, p)
 		}
 	}
 }
 
-func addImage(doc *docx.Docx, p *docx.Paragraph, n *html.Node) error {
+func addImage(im *htmlImages, doc *docx.Docx, p *docx.Paragraph, n *html.Node) error {
 	var src string
 	for _, attr := range n.Attr {
 		if attr.Key == "src" {
@@ -161,21 +216,37 @@ func addImage(doc *docx.Docx, p *docx.Paragraph, n *html.Node) error {
 		if err != nil {
 			return err
 		}
+		if err := im.take(int64(len(data))); err != nil {
+			return err
+		}
 		_, err = p.AddInlineDrawing(data)
 		if err != nil {
 			return err
 		}
 	} else if strings.HasPrefix(src, "http") {
-		resp, err := http.Get(src)
+		req, err := http.NewRequestWithContext(im.ctx, http.MethodGet, src, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
 		if err != nil {
 			return err
 		}
 		defer resp.Body.Close()
 
-		data, err := io.ReadAll(resp.Body)
+		// Reading one byte more than is left tells an image over the limit
+		// without reading all of it
+		var body io.Reader = resp.Body
+		if im.left >= 0 {
+			body = io.LimitReader(resp.Body, im.left+1)
+		}
+		data, err := io.ReadAll(body)
 		if err != nil {
 			return err
 		}
+		if err := im.take(int64(len(data))); err != nil {
+			return err
+		}
 
 		_, err = p.AddInlineDrawing(data)
 		if err != nil {
@@ -184,11 +255,15 @@ func addImage(doc *docx.Docx, p *docx.Paragraph, n *html.Node) error {
 	} else {
 		// Local file
 		// Verify file exists
-		if _, err := os.Stat(src); err != nil {
+		fi, err := os.Stat(src)
+		if err != nil {
+			return err
+		}
+		if err := im.take(fi.Size()); err != nil {
 			return err
 		}
 
-		_, err := p.AddInlineDrawingFrom(src)
+		_, err = p.AddInlineDrawingFrom(src)
 		if err != nil {
 			return err
 		}
@@ -1,10 +1,17 @@
 package docxexp
 
 import (
+	"os"
+
 	"github.com/fumiama/go-docx"
 )
 
-// ImageInjector injects an image into the document
+// emuPerPixel is the number of English Metric Units of a pixel at 96 DPI
+const emuPerPixel = 9525
+
+// ImageInjector injects an image into the document. Width and Height are in
+// pixels at 96 DPI: when only one is set the other keeps the aspect ratio of
+// the image, and when neither is the image is sized to the page.
 type ImageInjector struct {
 	Path   string
 	Width  int64
@@ -13,14 +20,68 @@ type ImageInjector struct {
 
 // Inject implements the Injector interface
 func (i ImageInjector) Inject(doc *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
-	// Add drawing
-	// p.AddInlineDrawingFrom takes a file path
-	_, err := p.AddInlineDrawingFrom(i.Path)
+	run, err := p.AddInlineDrawingFrom(i.Path)
 	if err != nil {
 		return nil, err
 	}
-
-	// Append run to paragraph children
-	// p.Children = append(p.Children, run) // Redundant
+	i.size(run)
 	return nil, nil
 }
+
+// InjectV2 implements the InjectorV2 interface. The image takes the place of
+// the inject action and is stored once in the document.
+func (i ImageInjector) InjectV2(ic *InjectContext) (Injection, error) {
+	data, err := os.ReadFile(i.Path)
+	if err != nil {
+		return Injection{}, err
+	}
+	run, err := ic.Image(data)
+	if err != nil {
+		return Injection{}, err
+	}
+	i.size(run)
+	return InlineRuns(run), nil
+}
+
+// size gives the drawings of run the size of the image
+func (i ImageInjector) size(run *docx.Run) {
+	if i.Width <= 0 && i.Height <= 0 {
+		return
+	}
+	for k, child := range run.Children {
+		d, ok := child.(*docx.Drawing)
+		if !ok || d.Inline == nil || d.Inline.Extent == nil || d.Inline.Extent.CX <= 0 || d.Inline.Extent.CY <= 0 {
+			continue
+		}
+		w, h := i.Width*emuPerPixel, i.Height*emuPerPixel
+		switch {
+		case w <= 0:
+			w = d.Inline.Extent.CX * h / d.Inline.Extent.CY
+		case h <= 0:
+			h = d.Inline.Extent.CY * w / d.Inline.Extent.CX
+		}
+		run.Children[k] = resized(d, w, h)
+	}
+}
+
+// resized returns a copy of the inline drawing d of w by h EMUs. The drawings
+// of InjectContext.Image are shared, so d is left as it is.
+func resized(d *docx.Drawing, w, h int64) *docx.Drawing {
+	inline := *d.Inline
+	extent := *inline.Extent
+	inline.Extent = &extent
+	if g := inline.Graphic; g != nil && g.GraphicData != nil && g.GraphicData.Pic != nil && g.GraphicData.Pic.SpPr != nil {
+		spPr := *g.GraphicData.Pic.SpPr
+		pic := *g.GraphicData.Pic
+		pic.SpPr = &spPr
+		data := *g.GraphicData
+		data.Pic = &pic
+		graphic := *g
+		graphic.GraphicData = &data
+		inline.Graphic = &graphic
+	}
+	inline.Size(w, h)
+	nd := *d
+	nd.Inline = &inline
+	return &nd
+}
@@ -0,0 +1,511 @@
+package docxexp
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+var (
+	styleRe          = regexp.MustCompile(`(?s)<w:style\b.*?</w:style>`)
+	styleIDRe        = regexp.MustCompile(`w:styleId="([^"]*)"`)
+	abstractNumRe    = regexp.MustCompile(`(?s)<w:abstractNum\b.*?</w:abstractNum>`)
+	numRe            = regexp.MustCompile(`(?s)<w:num\b.*?</w:num>`)
+	abstractNumIDRe  = regexp.MustCompile(`w:abstractNumId="(\d+)"`)
+	abstractNumRefRe = regexp.MustCompile(`(<w:abstractNumId w:val=")(\d+)`)
+	numIDRe          = regexp.MustCompile(`w:numId="(\d+)"`)
+	numRefRe         = regexp.MustCompile(`(<w:numId w:val=")(\d+)`)
+	styleRefRe       = regexp.MustCompile(`(<w:(?:basedOn|next|link) w:val=")([^"]*)`)
+)
+
+// parseIncludeTag parses an {{include expr}} tag, where expr gives the path
+// of the template, such as "legal.docx"
+func (t *DocxTemplate) parseIncludeTag(text string) (string, bool) {
+	content, ok := t.delims.blockTag(text)
+	if !ok || !strings.HasPrefix(content, "include ") {
+		return "", false
+	}
+	expr := strings.TrimSpace(strings.TrimPrefix(content, "include "))
+	return expr, expr != ""
+}
+
+// include renders the template at the path given by expr with data and the
+// {{set}} bindings in scope, and returns its body items ready to be spliced
+// into the document
+func (t *DocxTemplate) include(expr string, data interface{}) ([]interface{}, error) {
+	val, err := t.evalPipeline(expr, data)
+	if err != nil {
+		return nil, err
+	}
+	path, ok := val.(string)
+	if !ok || path == "" {
+		return nil, &BlockError{Tag: "include", Msg: fmt.Sprintf("include path %s is not a file name", expr)}
+	}
+	for _, p := range t.includes {
+		if p == path {
+			return nil, &BlockError{Tag: "include", Msg: fmt.Sprintf("template %s includes itself", path)}
+		}
+	}
+
+	b, err := t.readFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sub, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		return nil, fmt.Errorf("include %s: %w", path, err)
+	}
+	t.copyFuncs(sub)
+	sub.delims = t.delims
+	sub.opts = t.opts
+	sub.loc = Location{Part: path + ":" + documentPart}
+	sub.loops = append([]LoopFrame(nil), t.loops...)
+	sub.vars = append([]map[string]interface{}(nil), t.vars...)
+	sub.macros = sub.collectMacros(sub.doc.Document.Body.Items)
+	sub.includes = append(append([]string(nil), t.includes...), path)
+	sub.ctx, sub.usage = t.ctx, t.usage
+	if sub.usage != nil {
+		sub.seeImages(sub.doc.Document.Body.Items)
+	}
+
+	items, err := sub.traverseItems(sub.doc.Document.Body.Items, data)
+	t.errs = append(t.errs, sub.errs...)
+	if err != nil {
+		return nil, sub.newError(err, "")
+	}
+	return t.adopt(sub, items)
+}
+
+// readFile reads path from RenderOptions.IncludeFS, or from the working
+// directory when it is nil
+func (t *DocxTemplate) readFile(path string) ([]byte, error) {
+	if t.opts.IncludeFS != nil {
+		return fs.ReadFile(t.opts.IncludeFS, path)
+	}
+	return os.ReadFile(path)
+}
+
+// adopt moves the rendered items of the included template sub into the
+// document. Images and hyperlinks get relationships of the document, and
+// numbering definitions are copied under new IDs, as are styles whose ID the
+// document gives to another definition.
+func (t *DocxTemplate) adopt(sub *DocxTemplate, items []interface{}) ([]interface{}, error) {
+	numIDs, err := t.mergeNumbering(sub)
+	if err != nil {
+		return nil, err
+	}
+	styleIDs, err := t.mergeStyles(sub, numIDs)
+	if err != nil {
+		return nil, err
+	}
+	return t.adoptItems(sub, items, numIDs, styleIDs, nil)
+}
+
+// adoptItems copies items of sub into the document, giving images and
+// hyperlinks relationships of the document and numbering and style IDs the
+// IDs in numIDs and styleIDs.
+// section, if set, copies the section ended by a sectionMarker of sub and
+// returns its index in the document.
+func (t *DocxTemplate) adoptItems(sub *DocxTemplate, items []interface{}, numIDs, styleIDs map[string]string, section func(int) (int, error)) ([]interface{}, error) {
+	return t.newAdopter(sub, numIDs, styleIDs, section).items(items)
+}
+
+// newAdopter returns an adopter of the items of sub, as adoptItems describes
+func (t *DocxTemplate) newAdopter(sub *DocxTemplate, numIDs, styleIDs map[string]string, section func(int) (int, error)) *adopter {
+	// scratch is a detached paragraph of the document used to add images
+	// and hyperlinks to it
+	body := t.doc.Document.Body.Items
+	scratch := t.doc.AddParagraph()
+	t.doc.Document.Body.Items = body
+
+	return &adopter{from: sub.doc, scratch: scratch, numIDs: numIDs, styleIDs: styleIDs, section: section, rids: make(map[string]string)}
+}
+
+// items returns copies of items
+func (a *adopter) items(items []interface{}) ([]interface{}, error) {
+	var out []interface{}
+	for _, item := range items {
+		switch it := item.(type) {
+		case *docx.SectPr:
+			// Section properties are not carried over with the items
+			continue
+		case *docx.Paragraph:
+			p, err := a.paragraph(it)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, p)
+		case *docx.Table:
+			tbl, err := a.table(it)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, tbl)
+		default:
+			out = append(out, item)
+		}
+	}
+	return out, nil
+}
+
+// adopter copies items of an included template, which may share runs and
+// drawings between loop iterations, rewriting their references
+type adopter struct {
+	from     *docx.Docx
+	scratch  *docx.Paragraph
+	numIDs   map[string]string
+	styleIDs map[string]string
+	section  func(int) (int, error)
+	// rids maps the relationship IDs of from to those of the document
+	rids map[string]string
+	// shared are the relationship IDs of from that the document has too,
+	// kept as they are
+	shared map[string]bool
+}
+
+func (a *adopter) table(tbl *docx.Table) (*docx.Table, error) {
+	nt := *tbl
+	if props := tbl.TableProperties; props != nil && props.Style != nil {
+		if id, ok := a.styleIDs[props.Style.Val]; ok {
+			np := *props
+			np.Style = &docx.WTableStyle{Val: id}
+			nt.TableProperties = &np
+		}
+	}
+	nt.TableRows = make([]*docx.WTableRow, len(tbl.TableRows))
+	for i, row := range tbl.TableRows {
+		nr := *row
+		nr.TableCells = make([]*docx.WTableCell, len(row.TableCells))
+		for j, cell := range row.TableCells {
+			nc := *cell
+			nc.Paragraphs = make([]*docx.Paragraph, len(cell.Paragraphs))
+			for k, p := range cell.Paragraphs {
+				np, err := a.paragraph(p)
+				if err != nil {
+					return nil, err
+				}
+				nc.Paragraphs[k] = np
+			}
+			nc.Tables = make([]*docx.Table, len(cell.Tables))
+			for k, inner := range cell.Tables {
+				ni, err := a.table(inner)
+				if err != nil {
+					return nil, err
+				}
+				nc.Tables[k] = ni
+			}
+			nr.TableCells[j] = &nc
+		}
+		nt.TableRows[i] = &nr
+	}
+	return &nt, nil
+}
+
+func (a *adopter) paragraph(p *docx.Paragraph) (*docx.Paragraph, error) {
+	np := *p
+	if num := numPr(p); num != nil {
+		if id, ok := a.numIDs[num.NumID.Val]; ok {
+			props := *p.Properties
+			numProps := *num
+			numProps.NumID = &docx.NumID{Val: id}
+			props.NumProperties = &numProps
+			np.Properties = &props
+		}
+	}
+	if props := np.Properties; props != nil && props.Style != nil {
+		if id, ok := a.styleIDs[props.Style.Val]; ok {
+			nprops := *props
+			nprops.Style = &docx.Style{Val: id}
+			np.Properties = &nprops
+		}
+	}
+	np.Children = make([]interface{}, len(p.Children))
+	for i, child := range p.Children {
+		switch c := child.(type) {
+		case *docx.Run:
+			run, err := a.run(c)
+			if err != nil {
+				return nil, err
+			}
+			np.Children[i] = run
+		case *docx.Hyperlink:
+			h := *c
+			if h.ID != "" {
+				rid, err := a.link(c.ID)
+				if err != nil {
+					return nil, err
+				}
+				h.ID = rid
+			}
+			run, err := a.run(&c.Run)
+			if err != nil {
+				return nil, err
+			}
+			h.Run = *run
+			np.Children[i] = &h
+		case *sectionMarker:
+			np.Children[i] = child
+			if a.section != nil {
+				index, err := a.section(c.index)
+				if err != nil {
+					return nil, err
+				}
+				np.Children[i] = &sectionMarker{index: index}
+			}
+		default:
+			np.Children[i] = child
+		}
+	}
+	return &np, nil
+}
+
+// numPr returns the numbering properties of p, if it has a numbering ID
+func numPr(p *docx.Paragraph) *docx.NumProperties {
+	if p.Properties == nil || p.Properties.NumProperties == nil || p.Properties.NumProperties.NumID == nil {
+		return nil
+	}
+	return p.Properties.NumProperties
+}
+
+func (a *adopter) run(r *docx.Run) (*docx.Run, error) {
+	nr := *r
+	if props := r.RunProperties; props != nil && props.RunStyle != nil {
+		if id, ok := a.styleIDs[props.RunStyle.Val]; ok {
+			nprops := *props
+			nprops.RunStyle = &docx.RunStyle{Val: id}
+			nr.RunProperties = &nprops
+		}
+	}
+	nr.Children = make([]interface{}, len(r.Children))
+	for i, rc := range r.Children {
+		d, ok := rc.(*docx.Drawing)
+		if !ok {
+			nr.Children[i] = rc
+			continue
+		}
+		nd, err := a.drawing(d)
+		if err != nil {
+			return nil, err
+		}
+		nr.Children[i] = nd
+	}
+	return &nr, nil
+}
+
+// drawing returns a copy of the picture d embedding its image from the
+// document. Drawings other than pictures are kept as they are.
+func (a *adopter) drawing(d *docx.Drawing) (*docx.Drawing, error) {
+	var graphic *docx.AGraphic
+	switch {
+	case d.Inline != nil:
+		graphic = d.Inline.Graphic
+	case d.Anchor != nil:
+		graphic = d.Anchor.Graphic
+	}
+	if graphic == nil || graphic.GraphicData == nil || graphic.GraphicData.Pic == nil ||
+		graphic.GraphicData.Pic.BlipFill == nil || a.shared[graphic.GraphicData.Pic.BlipFill.Blip.Embed] {
+		return d, nil
+	}
+	rid, docPr, err := a.image(graphic.GraphicData.Pic.BlipFill.Blip.Embed)
+	if err != nil {
+		return nil, err
+	}
+
+	blipFill := *graphic.GraphicData.Pic.BlipFill
+	blipFill.Blip.Embed = rid
+	pic := *graphic.GraphicData.Pic
+	pic.BlipFill = &blipFill
+	data := *graphic.GraphicData
+	data.Pic = &pic
+	ng := *graphic
+	ng.GraphicData = &data
+
+	nd := *d
+	if d.Inline != nil {
+		inline := *d.Inline
+		inline.Graphic = &ng
+		inline.DocPr = docPr
+		nd.Inline = &inline
+	} else {
+		anchor := *d.Anchor
+		anchor.Graphic = &ng
+		anchor.DocPr = docPr
+		nd.Anchor = &anchor
+	}
+	return &nd, nil
+}
+
+// image copies the image embedded as rid into the document and returns its
+// new relationship ID and drawing properties with a fresh ID
+func (a *adopter) image(rid string) (string, *docx.WPDocPr, error) {
+	target, err := a.from.ReferTarget(rid)
+	if err != nil {
+		return "", nil, fmt.Errorf("image %s: %w", rid, err)
+	}
+	m := a.from.Media(strings.TrimPrefix(target, "media/"))
+	if m == nil {
+		return "", nil, fmt.Errorf("image %s: media %s not found", rid, target)
+	}
+	run, err := a.scratch.AddInlineDrawing(m.Data)
+	if err != nil {
+		return "", nil, fmt.Errorf("image %s: %w", target, err)
+	}
+	inline := run.Children[0].(*docx.Drawing).Inline
+	return inline.Graphic.GraphicData.Pic.BlipFill.Blip.Embed, inline.DocPr, nil
+}
+
+// link returns the document relationship for the hyperlink rid
+func (a *adopter) link(rid string) (string, error) {
+	if a.shared[rid] {
+		return rid, nil
+	}
+	if id, ok := a.rids[rid]; ok {
+		return id, nil
+	}
+	target, err := a.from.ReferTarget(rid)
+	if err != nil {
+		return "", fmt.Errorf("hyperlink %s: %w", rid, err)
+	}
+	id := a.scratch.AddLink("", target).ID
+	a.rids[rid] = id
+	return id, nil
+}
+
+// mergeNumbering copies the numbering definitions of sub under IDs following
+// those of the document and returns the new ID of each numbering ID of sub
+func (t *DocxTemplate) mergeNumbering(sub *DocxTemplate) (map[string]string, error) {
+	src, err := sub.packagePart(numberingPart)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	dst, err := t.packagePart(numberingPart)
+	if err != nil {
+		return nil, err
+	}
+	t.merged.declare(src, "w:numbering")
+	abstractBase := maxID(dst, abstractNumIDRe) + 1
+	// Numbering IDs start at 1, as 0 removes numbering
+	numBase := max(maxID(dst, numIDRe), 0)
+	shift := func(base int) func(string) string {
+		return func(id string) string {
+			n, _ := strconv.Atoi(id)
+			return strconv.Itoa(n + base)
+		}
+	}
+	abstractID, numID := shift(abstractBase), shift(numBase)
+
+	for _, def := range abstractNumRe.FindAllString(string(src), -1) {
+		def = replaceGroup(abstractNumIDRe, def, abstractID, 1)
+		t.merged.abstractNums = append(t.merged.abstractNums, def)
+	}
+	numIDs := make(map[string]string)
+	for _, def := range numRe.FindAllString(string(src), -1) {
+		if m := numIDRe.FindStringSubmatch(def); m != nil {
+			numIDs[m[1]] = numID(m[1])
+		}
+		def = replaceGroup(numIDRe, def, numID, 1)
+		def = replaceGroup(abstractNumRefRe, def, abstractID, 2)
+		t.merged.nums = append(t.merged.nums, def)
+	}
+	return numIDs, nil
+}
+
+// mergeStyles copies the styles of sub into the document. A style with the
+// same ID and definition as one of the document is not copied again; one
+// whose definition differs is copied under a new ID. It returns the new IDs
+// by the IDs of sub.
+func (t *DocxTemplate) mergeStyles(sub *DocxTemplate, numIDs map[string]string) (map[string]string, error) {
+	src, err := sub.packagePart(stylesPart)
+	if err != nil || src == nil {
+		return nil, err
+	}
+	dst, err := t.packagePart(stylesPart)
+	if err != nil {
+		return nil, err
+	}
+	t.merged.declare(src, "w:styles")
+	// defined holds the definitions of the document by style ID
+	defined := make(map[string]string)
+	for _, style := range append(styleRe.FindAllString(string(dst), -1), t.merged.styles...) {
+		if m := styleIDRe.FindStringSubmatch(style); m != nil {
+			defined[m[1]] = style
+		}
+	}
+	styleIDs := make(map[string]string)
+	var copied []string
+	for _, style := range styleRe.FindAllString(string(src), -1) {
+		m := styleIDRe.FindStringSubmatch(style)
+		if m == nil {
+			continue
+		}
+		style = replaceGroup(numRefRe, style, func(id string) string {
+			if n, ok := numIDs[id]; ok {
+				return n
+			}
+			return id
+		}, 2)
+		id, def := m[1], style
+		for n := 1; defined[id] != "" && defined[id] != def; n++ {
+			id = m[1] + strconv.Itoa(n)
+			def = renameStyle(style, id, n)
+		}
+		if id != m[1] {
+			styleIDs[m[1]] = id
+		}
+		if defined[id] == "" {
+			defined[id] = def
+			copied = append(copied, def)
+		}
+	}
+	// Copied styles based on a renamed style follow it
+	for _, style := range copied {
+		t.merged.styles = append(t.merged.styles, replaceGroup(styleRefRe, style, func(id string) string {
+			if n, ok := styleIDs[id]; ok {
+				return n
+			}
+			return id
+		}, 2))
+	}
+	return styleIDs, nil
+}
+
+// renameStyle gives the style definition style the ID id and the n-th
+// variant of its name
+func renameStyle(style, id string, n int) string {
+	style = replaceGroup(styleIDRe, style, func(string) string { return id }, 1)
+	return replaceGroup(styleNameRe, style, func(name string) string {
+		return name + " " + strconv.Itoa(n)
+	}, 1)
+}
+
+// maxID returns the largest ID captured by re in data, or -1 if none is
+func maxID(data []byte, re *regexp.Regexp) int {
+	max := -1
+	for _, m := range re.FindAllSubmatch(data, -1) {
+		if n, err := strconv.Atoi(string(m[1])); err == nil && n > max {
+			max = n
+		}
+	}
+	return max
+}
+
+// replaceGroup replaces the submatch group of every match of re in s with
+// its image by fn
+func replaceGroup(re *regexp.Regexp, s string, fn func(string) string, group int) string {
+	var sb strings.Builder
+	last := 0
+	for _, loc := range re.FindAllStringSubmatchIndex(s, -1) {
+		start, end := loc[2*group], loc[2*group+1]
+		sb.WriteString(s[last:start])
+		sb.WriteString(fn(s[start:end]))
+		last = end
+	}
+	sb.WriteString(s[last:])
+	return sb.String()
+}
@@ -0,0 +1,215 @@
+package docxexp
+
+import (
+	"bytes"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/fumiama/go-docx"
+)
+
+// listPackage returns a package holding a paragraph for each line, numbered
+// by the list of numbering ID 1 and styled with styleID, a style of the
+// package giving its text color
+func listPackage(t *testing.T, styleID, color string, lines ...string) []byte {
+	t.Helper()
+	data := packageOf(t, func(doc *docx.Docx) {
+		for _, line := range lines {
+			p := doc.AddParagraph()
+			p.AddText(line)
+			p.Properties = &docx.ParagraphProperties{
+				Style:         &docx.Style{Val: styleID},
+				NumProperties: &docx.NumProperties{NumID: &docx.NumID{Val: "1"}, Ilvl: &docx.Ilevel{Val: "0"}},
+			}
+		}
+	})
+	style := `<w:style w:type="paragraph" w:styleId="` + styleID + `"><w:name w:val="` + styleID +
+		`"/><w:rPr><w:color w:val="` + color + `"/></w:rPr></w:style>`
+	numbering := xmlPart("w:numbering")
+	numbering = strings.Replace(numbering, "></w:numbering>", `><w:abstractNum w:abstractNumId="0"><w:lvl w:ilvl="0">`+
+		`<w:start w:val="1"/><w:numFmt w:val="decimal"/><w:lvlText w:val="%1."/></w:lvl></w:abstractNum>`+
+		`<w:num w:numId="1"><w:abstractNumId w:val="0"/></w:num></w:numbering>`, 1)
+	var buf bytes.Buffer
+	err := rewritePackage(data, &buf, map[string]func([]byte) ([]byte, error){
+		stylesPart: func(part []byte) ([]byte, error) {
+			return insertBefore(part, style, "</w:styles>"), nil
+		},
+		numberingPart: func([]byte) ([]byte, error) {
+			return []byte(numbering), nil
+		},
+		relsPart: func(part []byte) ([]byte, error) {
+			return insertBefore(part, `<Relationship Id="rId100" Type="http://schemas.openxmlformats.org/officeDocument/2006/relationships/numbering" Target="numbering.xml"/>`, "</Relationships>"), nil
+		},
+		typesPart: func(part []byte) ([]byte, error) {
+			return insertBefore(part, `<Override PartName="/word/numbering.xml" ContentType="application/vnd.openxmlformats-officedocument.wordprocessingml.numbering+xml"/>`, "</Types>"), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// numIDs returns the numbering ID of each paragraph of the body of tpl, ""
+// for those that are not numbered
+func numIDs(tpl *DocxTemplate) []string {
+	var ids []string
+	for _, item := range tpl.doc.Document.Body.Items {
+		if p, ok := item.(*docx.Paragraph); ok {
+			if n := numPr(p); n != nil && n.NumID != nil {
+				ids = append(ids, n.NumID.Val)
+			} else {
+				ids = append(ids, "")
+			}
+		}
+	}
+	return ids
+}
+
+// paragraphStyles returns the style of each styled paragraph of the body of
+// tpl
+func paragraphStyles(tpl *DocxTemplate) []string {
+	var ids []string
+	for _, item := range tpl.doc.Document.Body.Items {
+		if p, ok := item.(*docx.Paragraph); ok && p.Properties != nil && p.Properties.Style != nil {
+			ids = append(ids, p.Properties.Style.Val)
+		}
+	}
+	return ids
+}
+
+// styleColor returns the text color of the style id in styles, "" if there
+// is no such style
+func styleColor(styles, id string) string {
+	for _, def := range styleRe.FindAllString(styles, -1) {
+		if m := styleIDRe.FindStringSubmatch(def); m != nil && m[1] == id {
+			_, color, _ := strings.Cut(def, `<w:color w:val="`)
+			color, _, _ = strings.Cut(color, `"`)
+			return color
+		}
+	}
+	return ""
+}
+
+func TestInclude(t *testing.T) {
+	fsys := fstest.MapFS{
+		"part.docx":  {Data: listPackage(t, "Part", "00FF00", "part {{ .Name }} {{ total }}")},
+		"plain.docx": {Data: packageOf(t, func(doc *docx.Docx) { doc.AddParagraph().AddText("plain {{ .Name }}") })},
+		"item.docx":  {Data: packageOf(t, func(doc *docx.Docx) { doc.AddParagraph().AddText("item {{ i }}") })},
+	}
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+		err   bool
+	}{
+		{"plain", []string{"a", `{{include "plain.docx"}}`, "b"}, []string{"a", "plain Ann", "b"}, false},
+		{"expression", []string{"{{include .File}}"}, []string{"plain Ann"}, false},
+		{"set bindings", []string{"{{set total = 2}}", `{{include "part.docx"}}`}, []string{"part Ann 2"}, false},
+		{"in loop", []string{"{{for i in Items}}", `{{include "item.docx"}}`, "{{endfor}}"}, []string{"item 1", "item 2"}, false},
+		{"missing", []string{`{{include "none.docx"}}`}, nil, true},
+	}
+	data := map[string]interface{}{"Name": "Ann", "File": "plain.docx", "Items": []int{1, 2}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			err := tpl.RenderWithOptions(data, RenderOptions{IncludeFS: fsys})
+			if tt.err {
+				if err == nil {
+					t.Fatal("rendered, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIncludeNumberingAndStyles(t *testing.T) {
+	fsys := fstest.MapFS{
+		"part.docx":   {Data: listPackage(t, "Part", "00FF00", "included")},
+		"shared.docx": {Data: listPackage(t, "Main", "0000FF", "shared")},
+		"same.docx":   {Data: listPackage(t, "Main", "FF0000", "same")},
+	}
+	main := listPackage(t, "Main", "FF0000", "main", `{{include "part.docx"}}`, `{{include "shared.docx"}}`,
+		`{{include "same.docx"}}`, `{{include "shared.docx"}}`)
+	tpl, err := New(bytes.NewReader(main), int64(len(main)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tpl.RenderWithOptions(nil, RenderOptions{IncludeFS: fsys}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each included list continues under a numbering ID of its own
+	if got, want := numIDs(tpl), []string{"1", "2", "3", "4", "5"}; !slices.Equal(got, want) {
+		t.Errorf("numbering IDs = %q, want %q", got, want)
+	}
+	numbering := savedPart(t, tpl, numberingPart)
+	for _, def := range []string{
+		`<w:num w:numId="2"><w:abstractNumId w:val="1"/>`,
+		`<w:num w:numId="3"><w:abstractNumId w:val="2"/>`,
+		`<w:abstractNum w:abstractNumId="2">`,
+	} {
+		if !strings.Contains(numbering, def) {
+			t.Errorf("numbering.xml lacks %s", def)
+		}
+	}
+
+	// Styles are copied once, under a new ID when the including template
+	// defines another style with their ID
+	if got, want := paragraphStyles(tpl), []string{"Main", "Part", "Main1", "Main", "Main1"}; !slices.Equal(got, want) {
+		t.Errorf("paragraph styles = %q, want %q", got, want)
+	}
+	styles := savedPart(t, tpl, stylesPart)
+	tests := []struct{ id, color string }{
+		{"Main", "FF0000"},
+		{"Part", "00FF00"},
+		{"Main1", "0000FF"},
+	}
+	for _, tt := range tests {
+		if got := styleColor(styles, tt.id); got != tt.color {
+			t.Errorf("style %s color = %q, want %q", tt.id, got, tt.color)
+		}
+		if n := strings.Count(styles, `w:styleId="`+tt.id+`"`); n != 1 {
+			t.Errorf("style %s defined %d times, want once", tt.id, n)
+		}
+	}
+	if !strings.Contains(styles, `<w:name w:val="Main 1"/>`) {
+		t.Error("style Main1 is not named Main 1")
+	}
+}
+
+func TestIncludeItself(t *testing.T) {
+	fsys := fstest.MapFS{"self.docx": {Data: packageOf(t, func(doc *docx.Docx) { doc.AddParagraph().AddText(`{{include "self.docx"}}`) })}}
+	err := textTemplate(t, `{{include "self.docx"}}`).RenderWithOptions(nil, RenderOptions{IncludeFS: fsys})
+	var be *BlockError
+	if !errors.As(err, &be) || be.Tag != "include" {
+		t.Errorf("err = %v, want an include BlockError", err)
+	}
+}
+
+func TestIncludeFuncs(t *testing.T) {
+	fsys := fstest.MapFS{"cell.docx": {Data: packageOf(t, func(doc *docx.Docx) {
+		doc.AddTable(1, 1, 0, nil).TableRows[0].TableCells[0].AddParagraph().AddText(`{{ cellColor "00FF00" }}{{ shout .Name }}`)
+	})}}
+	tpl := textTemplate(t, `{{include "cell.docx"}}`)
+	tpl.Funcs(map[string]interface{}{"shout": strings.ToUpper})
+	if err := tpl.RenderWithOptions(map[string]interface{}{"Name": "ann"}, RenderOptions{IncludeFS: fsys}); err != nil {
+		t.Fatal(err)
+	}
+	document := savedPart(t, tpl, documentPart)
+	for _, want := range []string{`w:fill="00FF00"`, ">ANN<"} {
+		if !strings.Contains(document, want) {
+			t.Errorf("document.xml lacks %s", want)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package docxexp
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// InjectorV2 is the interface of injectors given the render state through an
+// InjectContext. Values implementing both InjectorV2 and Injector are injected
+// as InjectorV2.
+type InjectorV2 interface {
+	InjectV2(ic *InjectContext) (Injection, error)
+}
+
+// Injection is the content made by an InjectorV2. Inline runs take the place
+// of the inject action within its paragraph; block items, paragraphs and
+// tables, replace the paragraph.
+type Injection struct {
+	Block bool
+	Runs  []*docx.Run
+	Items []interface{}
+}
+
+// InlineRuns returns an Injection putting runs in place of the inject action
+func InlineRuns(runs ...*docx.Run) Injection {
+	return Injection{Runs: runs}
+}
+
+// BlockItems returns an Injection replacing the paragraph with items. The
+// paragraph is removed when there are none.
+func BlockItems(items ...interface{}) Injection {
+	return Injection{Block: true, Items: items}
+}
+
+// InjectContext is the render state given to an InjectorV2
+type InjectContext struct {
+	// Context is the context of the render, see RenderContext
+	Context context.Context
+	Doc     *docx.Docx
+	// Paragraph holds the inject action. Inline injections should leave it
+	// as it is.
+	Paragraph *docx.Paragraph
+	// Cell is the table cell being rendered, nil outside of tables
+	Cell *docx.WTableCell
+	// Location and Loops are where the paragraph is, as RenderError reports
+	Location Location
+	Loops    []LoopFrame
+	Options  RenderOptions
+	// Data is the data of the scope, such as the loop variables
+	Data interface{}
+
+	t *DocxTemplate
+}
+
+// Lookup returns the value of the dotted path expr, such as .Project.Name, in
+// the scope of the inject action, {{set}} bindings included. Missing values
+// are handled as RenderOptions.MissingKey selects.
+func (ic *InjectContext) Lookup(expr string) (interface{}, error) {
+	return ic.t.evaluateExpression(expr, ic.Data)
+}
+
+// Eval returns the value of the template pipeline expr, which may call the
+// template functions, in the scope of the inject action
+func (ic *InjectContext) Eval(expr string) (interface{}, error) {
+	return ic.t.evalPipeline(expr, ic.Data)
+}
+
+var styleNameRe = regexp.MustCompile(`<w:name w:val="([^"]*)"`)
+
+// Style returns the ID of the style of the document with the ID or name
+// style, such as "Heading1" or "heading 1". Names are matched regardless of
+// case, as Word does.
+func (ic *InjectContext) Style(style string) (string, bool) {
+	return ic.t.styleID(style)
+}
+
+// styleID returns the ID of the style of the document with the ID or name
+// style
+func (t *DocxTemplate) styleID(style string) (string, bool) {
+	data, err := t.packagePart(stylesPart)
+	if err != nil || data == nil {
+		return "", false
+	}
+	var byName string
+	for _, def := range styleRe.FindAllString(string(data), -1) {
+		id := styleIDRe.FindStringSubmatch(def)
+		if id == nil {
+			continue
+		}
+		if id[1] == style {
+			return id[1], true
+		}
+		if name := styleNameRe.FindStringSubmatch(def); byName == "" && name != nil && strings.EqualFold(name[1], style) {
+			byName = id[1]
+		}
+	}
+	return byName, byName != ""
+}
+
+// Open reads the resource name from RenderOptions.IncludeFS, or from the
+// working directory when it is nil
+func (ic *InjectContext) Open(name string) ([]byte, error) {
+	return ic.t.readFile(name)
+}
+
+// Image returns a run holding the image data, sized to the page as
+// AddInlineDrawing does. Images with the same data are stored once in the
+// document.
+func (ic *InjectContext) Image(data []byte) (*docx.Run, error) {
+	sum := sha256.Sum256(data)
+	run, ok := ic.t.images[sum]
+	if !ok {
+		var err error
+		if run, err = createParagraph(ic.Doc).AddInlineDrawing(data); err != nil {
+			return nil, err
+		}
+		if ic.t.images == nil {
+			ic.t.images = make(map[[sha256.Size]byte]*docx.Run)
+		}
+		ic.t.images[sum] = run
+	}
+	copied := *run
+	copied.Children = append([]interface{}(nil), run.Children...)
+	return &copied, nil
+}
+
+// ImageBytesLeft returns the number of image bytes the render may still add
+// under Limits.MaxImageBytes, false when there is no such limit
+func (ic *InjectContext) ImageBytesLeft() (int64, bool) {
+	limit := ic.Options.Limits.MaxImageBytes
+	if limit <= 0 || ic.t.usage == nil {
+		return 0, false
+	}
+	return max(0, limit-ic.t.usage.imageBytes), true
+}
+
+// AdaptInjector returns inj as an InjectorV2. Items returned by inj are
+// block items and nil is an inline injection of nothing, the paragraph as
+// inj left it. ContextInjectors are given InjectContext.Context.
+func AdaptInjector(inj Injector) InjectorV2 {
+	if v2, ok := inj.(InjectorV2); ok {
+		return v2
+	}
+	return injectorAdapter{inj}
+}
+
+type injectorAdapter struct {
+	Injector
+}
+
+func (a injectorAdapter) InjectV2(ic *InjectContext) (Injection, error) {
+	var items []interface{}
+	var err error
+	if ci, ok := a.Injector.(ContextInjector); ok {
+		items, err = ci.InjectWithContext(ic.Context, ic.Doc, ic.Paragraph)
+	} else {
+		items, err = a.Inject(ic.Doc, ic.Paragraph)
+	}
+	if err != nil || items == nil {
+		return Injection{}, err
+	}
+	return BlockItems(items...), nil
+}
+
+// injectable returns v, passed to the inject template function, as an
+// InjectorV2
+func injectable(v interface{}) (InjectorV2, error) {
+	switch inj := v.(type) {
+	case nil:
+		return nil, errors.New("inject of a nil value")
+	case InjectorV2:
+		return inj, nil
+	case Injector:
+		return injectorAdapter{inj}, nil
+	}
+	return nil, fmt.Errorf("inject of %T, which is not an Injector", v)
+}
+
+// injected returns the value passed to inject that inj was made from
+func injected(inj InjectorV2) interface{} {
+	if a, ok := inj.(injectorAdapter); ok {
+		return a.Injector
+	}
+	return inj
+}
+
+// injectContext returns the InjectContext of the paragraph p being rendered
+func (t *DocxTemplate) injectContext(p *docx.Paragraph, data interface{}) *InjectContext {
+	ctx := t.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return &InjectContext{
+		Context:   ctx,
+		Doc:       t.doc,
+		Paragraph: p,
+		Cell:      t.cell,
+		Location:  t.location(),
+		Loops:     append([]LoopFrame(nil), t.loops...),
+		Options:   t.opts,
+		Data:      data,
+		t:         t,
+	}
+}
+
+// placeRuns puts runs in place of the placeholder id in p, splitting the run
+// holding it. Runs without properties take those of that run. The runs are
+// appended to p when id is not found.
+func placeRuns(p *docx.Paragraph, id string, runs []*docx.Run) {
+	for i, child := range p.Children {
+		run, ok := child.(*docx.Run)
+		if !ok {
+			continue
+		}
+		for j, rc := range run.Children {
+			text, ok := rc.(*docx.Text)
+			if !ok || !strings.Contains(text.Text, id) {
+				continue
+			}
+			before, after := *text, *text
+			before.Text, after.Text, _ = strings.Cut(text.Text, id)
+			before.XMLSpace, after.XMLSpace = "preserve", "preserve"
+			head, tail := *run, *run
+			head.Children = append(append([]interface{}(nil), run.Children[:j]...), &before)
+			tail.Children = append([]interface{}{&after}, run.Children[j+1:]...)
+
+			children := append(append([]interface{}(nil), p.Children[:i]...), &head)
+			for _, r := range runs {
+				if r.RunProperties == nil && run.RunProperties != nil {
+					// The runs belong to the injector, which may use them again
+					placed := *r
+					props := *run.RunProperties
+					placed.RunProperties = &props
+					r = &placed
+				}
+				children = append(children, r)
+			}
+			p.Children = append(append(children, &tail), p.Children[i+1:]...)
+			return
+		}
+	}
+	for _, r := range runs {
+		p.Children = append(p.Children, r)
+	}
+}
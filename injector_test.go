@@ -0,0 +1,172 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"os"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// injectorFunc is an InjectorV2 calling itself
+type injectorFunc func(ic *InjectContext) (Injection, error)
+
+func (f injectorFunc) InjectV2(ic *InjectContext) (Injection, error) {
+	return f(ic)
+}
+
+// textRun returns a run holding text
+func textRun(text string) *docx.Run {
+	return &docx.Run{Children: []interface{}{&docx.Text{Text: text}}}
+}
+
+func TestInjectorV2(t *testing.T) {
+	shared := textRun("X")
+	inline := injectorFunc(func(*InjectContext) (Injection, error) { return InlineRuns(shared), nil })
+	block := injectorFunc(func(ic *InjectContext) (Injection, error) {
+		var items []interface{}
+		for _, text := range []string{"x", "y"} {
+			p := createParagraph(ic.Doc)
+			p.AddText(text)
+			items = append(items, p)
+		}
+		return BlockItems(items...), nil
+	})
+	none := injectorFunc(func(*InjectContext) (Injection, error) { return BlockItems(), nil })
+	data := map[string]interface{}{"Inline": inline, "Block": block, "None": none}
+
+	t.Run("inline", func(t *testing.T) {
+		tpl := runTemplate(t, "a ", "*b {{ inject .Inline }} c*", " d")
+		if err := tpl.Render(data); err != nil {
+			t.Fatal(err)
+		}
+		// The injected run takes the formatting of the run it splits
+		if got, want := runTexts(tpl), []string{"a ", "*b *", "*X*", "* c*", " d"}; !slices.Equal(got, want) {
+			t.Errorf("runs = %q, want %q", got, want)
+		}
+		if shared.RunProperties != nil {
+			t.Error("the run of the injector was changed")
+		}
+	})
+
+	t.Run("block", func(t *testing.T) {
+		tpl := textTemplate(t, "a", "{{ inject .Block }}", "{{ inject .None }}", "b")
+		if err := tpl.Render(data); err != nil {
+			t.Fatal(err)
+		}
+		if got, want := texts(tpl), []string{"a", "x", "y", "b"}; !slices.Equal(got, want) {
+			t.Errorf("texts = %q, want %q", got, want)
+		}
+	})
+}
+
+func TestInjectContext(t *testing.T) {
+	img, err := os.ReadFile("testdata/test_image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var styles []string
+	inj := injectorFunc(func(ic *InjectContext) (Injection, error) {
+		// The style Normal of the go-docx package has the ID a
+		for _, name := range []string{"a", "normal", "Nope"} {
+			id, ok := ic.Style(name)
+			styles = append(styles, id+"/"+map[bool]string{true: "ok", false: "missing"}[ok])
+		}
+		name, err := ic.Lookup(".Name")
+		if err != nil {
+			return Injection{}, err
+		}
+		run, err := ic.Image(img)
+		if err != nil {
+			return Injection{}, err
+		}
+		return InlineRuns(textRun(name.(string)), run), nil
+	})
+	tpl := textTemplate(t, "{{ inject .I }}", "{{ inject .I }}")
+	if err := tpl.Render(map[string]interface{}{"I": inj, "Name": "Ann"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := texts(tpl), []string{"Ann", "Ann"}; !slices.Equal(got, want) {
+		t.Errorf("texts = %q, want %q", got, want)
+	}
+	if got, want := styles[:3], []string{"a/ok", "a/ok", "/missing"}; !slices.Equal(got, want) {
+		t.Errorf("styles = %q, want %q", got, want)
+	}
+
+	// The same image is stored once
+	var buf bytes.Buffer
+	if err := tpl.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := 0
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "word/media/") {
+			media++
+		}
+	}
+	if media != 1 {
+		t.Errorf("%d media files, want 1", media)
+	}
+	if n := strings.Count(savedPart(t, tpl, documentPart), "<w:drawing>"); n != 2 {
+		t.Errorf("%d drawings, want 2", n)
+	}
+}
+
+// itemsInjector returns its items, or writes into the paragraph when it has
+// none
+type itemsInjector []interface{}
+
+func (inj itemsInjector) Inject(_ *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
+	if inj == nil {
+		p.AddText("added")
+	}
+	return inj, nil
+}
+
+func TestAdaptInjector(t *testing.T) {
+	// Injectors that are also InjectorV2 are kept as they are
+	if _, ok := AdaptInjector(HTMLInjector{}).(HTMLInjector); !ok {
+		t.Error("an InjectorV2 is adapted")
+	}
+
+	doc := docx.New().WithDefaultTheme()
+	p := createParagraph(doc)
+	ic := &InjectContext{Context: context.Background(), Doc: doc, Paragraph: p}
+	item := createParagraph(doc)
+	res, err := AdaptInjector(itemsInjector{item}).InjectV2(ic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Block || len(res.Items) != 1 || res.Items[0] != item {
+		t.Errorf("injection = %+v, want the block item", res)
+	}
+
+	// Without items, the injection is inline and the paragraph as the
+	// injector left it
+	res, err = AdaptInjector(itemsInjector(nil)).InjectV2(ic)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Block || len(res.Runs) != 0 || len(p.Children) != 1 {
+		t.Errorf("injection = %+v with %d children, want an inline one", res, len(p.Children))
+	}
+
+	// Injectors given to inject are adapted
+	tpl := textTemplate(t, "a", "{{ inject .I }}")
+	injected := createParagraph(doc)
+	injected.AddText("b")
+	if err := tpl.Render(map[string]interface{}{"I": itemsInjector{injected}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := texts(tpl), []string{"a", "b"}; !slices.Equal(got, want) {
+		t.Errorf("texts = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,617 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template/parse"
+
+	"github.com/fumiama/go-docx"
+)
+
+// documentPart is the story part holding the document body
+const documentPart = "word/document.xml"
+
+// Location identifies a paragraph of the template
+type Location struct {
+	// Part is the package part holding the paragraph
+	Part string `json:"part"`
+	// Item is the index of the top-level body item holding the paragraph
+	Item int `json:"item"`
+	// Cells lists the table cells holding the paragraph, outermost first
+	Cells []CellRef `json:"cells,omitempty"`
+	// Paragraph is the index of the paragraph among the items of the body
+	// or of its innermost cell
+	Paragraph int `json:"paragraph"`
+	// Text is the text of the paragraph
+	Text string `json:"text,omitempty"`
+}
+
+// CellRef identifies a table cell
+type CellRef struct {
+	// Table is the index of the table among the items of its body or cell
+	Table int `json:"table"`
+	Row   int `json:"row"`
+	Cell  int `json:"cell"`
+}
+
+func (l Location) String() string {
+	var sb strings.Builder
+	sb.WriteString(l.Part)
+	fmt.Fprintf(&sb, " item %d", l.Item)
+	for _, c := range l.Cells {
+		fmt.Fprintf(&sb, " table %d row %d cell %d", c.Table, c.Row, c.Cell)
+	}
+	fmt.Fprintf(&sb, " paragraph %d", l.Paragraph)
+	return sb.String()
+}
+
+// Scope is a variable scope of the template: the root data, the body of a
+// {{for}} block, a table row repeated by {{ range }} or a {{define}} block
+type Scope struct {
+	// Kind is "root", "for", "range" or "define"
+	Kind string `json:"kind"`
+	// Variable is the loop variable of a {{for}} block or the name of a
+	// {{define}} block
+	Variable string `json:"variable,omitempty"`
+	// Source is the expression of the loop
+	Source   string    `json:"source,omitempty"`
+	Location *Location `json:"location,omitempty"`
+	// Fields are the variables referenced inside the scope
+	Fields []*Field `json:"fields,omitempty"`
+	// Injectors are the {{ inject }} slots inside the scope
+	Injectors []Slot `json:"injectors,omitempty"`
+	// Bindings are the names bound by {{set}} inside the scope
+	Bindings []string `json:"bindings,omitempty"`
+	Scopes   []*Scope `json:"scopes,omitempty"`
+}
+
+// Field is a referenced variable; Children are the fields used on its value
+type Field struct {
+	Name     string   `json:"name"`
+	Children []*Field `json:"children,omitempty"`
+}
+
+// Slot is an injector placeholder
+type Slot struct {
+	Expr     string   `json:"expr"`
+	Location Location `json:"location"`
+}
+
+// Diagnostic is a problem found in the template
+type Diagnostic struct {
+	Message  string   `json:"message"`
+	Location Location `json:"location"`
+}
+
+func (d Diagnostic) String() string {
+	return d.Location.String() + ": " + d.Message
+}
+
+// Tag is a template tag as it is written in the template
+type Tag struct {
+	Text     string   `json:"text"`
+	Location Location `json:"location"`
+}
+
+// Inspection is the result of Inspect
+type Inspection struct {
+	Root *Scope `json:"root"`
+	// Tags are all the tags of the template in document order
+	Tags        []Tag        `json:"tags,omitempty"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+}
+
+// Inspect parses every tag of the template without rendering it and returns
+// the variables, loop scopes and injector slots it references together with
+// the problems found. Every story part is walked: the document body, then the
+// headers, footers, footnotes and endnotes. Only the body is rendered, so the
+// tags of the other parts are also reported as diagnostics.
+func (t *DocxTemplate) Inspect() *Inspection {
+	in := &inspector{t: t, result: &Inspection{Root: &Scope{Kind: "root"}}}
+	in.loc.Part = documentPart
+	in.macros = make(map[string]bool)
+	for name := range t.collectMacros(t.doc.Document.Body.Items) {
+		in.macros[name] = true
+	}
+	in.walkItems(t.doc.Document.Body.Items, 0, in.result.Root, true)
+
+	for _, name := range storyParts(t.pkg) {
+		in.loc = Location{Part: name}
+		data, err := t.packagePart(name)
+		var stories [][]interface{}
+		if err == nil {
+			stories, err = readStories(data)
+		}
+		if err != nil {
+			in.report("%v", err)
+			continue
+		}
+		tags := len(in.result.Tags)
+		for _, items := range stories {
+			in.walkItems(items, 0, in.result.Root, true)
+		}
+		if len(in.result.Tags) > tags {
+			in.loc = Location{Part: name}
+			in.report("tags in %s are not rendered", name)
+		}
+	}
+	return in.result
+}
+
+// storyPartRe matches the story parts other than the document body
+var storyPartRe = regexp.MustCompile(`^word/(header\d*|footer\d*|footnotes|endnotes)\.xml$`)
+
+// storyParts returns the names of the story parts of pkg other than the
+// document body, sorted
+func storyParts(pkg *zip.Reader) []string {
+	var names []string
+	for _, f := range pkg.File {
+		if storyPartRe.MatchString(f.Name) {
+			names = append(names, f.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readStories returns the paragraphs and tables of the story part data, one
+// list per element holding them, such as each footnote
+func readStories(data []byte) ([][]interface{}, error) {
+	var stories [][]interface{}
+	var items []interface{}
+	// depth is the element depth and itemDepth that of the items, or -1
+	depth, itemDepth := 0, -1
+	flush := func() {
+		if len(items) > 0 {
+			stories = append(stories, items)
+		}
+		items, itemDepth = nil, -1
+	}
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			var item interface{}
+			switch tok.Name.Local {
+			case "p":
+				item = &docx.Paragraph{}
+			case "tbl":
+				item = &docx.Table{}
+			default:
+				depth++
+				continue
+			}
+			// go-docx passes on the xml.UnmarshalError of elements it does
+			// not know; malformed XML is a *xml.SyntaxError
+			var unknown xml.UnmarshalError
+			if err := d.DecodeElement(item, &tok); err != nil && !errors.As(err, &unknown) {
+				return nil, err
+			}
+			if itemDepth != depth {
+				flush()
+				itemDepth = depth
+			}
+			items = append(items, item)
+		case xml.EndElement:
+			depth--
+			if depth < itemDepth {
+				flush()
+			}
+		}
+	}
+	flush()
+	return stories, nil
+}
+
+// Lint returns the problems found in the template
+func (t *DocxTemplate) Lint() []Diagnostic {
+	return t.Inspect().Diagnostics
+}
+
+type inspector struct {
+	t      *DocxTemplate
+	result *Inspection
+	loc    Location
+	// vars are the names bound by {{set}}, one frame per walkItems call
+	vars []map[string]bool
+	// macros are the names of the blocks defined by {{define}}
+	macros map[string]bool
+}
+
+func (in *inspector) report(format string, args ...interface{}) {
+	in.result.Diagnostics = append(in.result.Diagnostics, Diagnostic{
+		Message:  fmt.Sprintf(format, args...),
+		Location: in.location(),
+	})
+}
+
+// addTags records the tags of text at the current location
+func (in *inspector) addTags(text string) {
+	for _, tag := range in.t.delims.findTags(text) {
+		in.result.Tags = append(in.result.Tags, Tag{Text: tag, Location: in.location()})
+	}
+}
+
+func (in *inspector) location() Location {
+	loc := in.loc
+	loc.Cells = append([]CellRef(nil), in.loc.Cells...)
+	return loc
+}
+
+// walkItems inspects items, which start at index base of their container.
+// top is set for items of the document body.
+func (in *inspector) walkItems(items []interface{}, base int, scope *Scope, top bool) {
+	in.vars = append(in.vars, make(map[string]bool))
+	defer func() { in.vars = in.vars[:len(in.vars)-1] }()
+	for i := 0; i < len(items); i++ {
+		if top {
+			in.loc.Item = base + i
+		}
+		in.loc.Paragraph = base + i
+		switch it := items[i].(type) {
+		case *docx.Paragraph:
+			text := in.t.getParagraphText(it)
+			in.loc.Text = text
+			in.addTags(text)
+			trimmed := strings.TrimSpace(text)
+
+			if in.t.delims.startsBlock(trimmed, "for") {
+				variable, sliceExpr, ok := in.t.parseForTag(text)
+				if !ok {
+					in.report("malformed tag %q, expected {{for var in slice}}", trimmed)
+					continue
+				}
+				end, err := in.t.findBlockEnd(items, i+1, "endfor")
+				if err != nil {
+					in.report("%v", err)
+					continue
+				}
+				in.addField(scope, sliceExpr)
+				loc := in.location()
+				inner := &Scope{Kind: "for", Variable: variable, Source: sliceExpr, Location: &loc}
+				scope.Scopes = append(scope.Scopes, inner)
+				in.walkItems(items[i+1:end], base+i+1, inner, top)
+				in.endTags(items, end, base, top)
+				i = end
+				continue
+			}
+			if in.t.delims.startsBlock(trimmed, "if") {
+				condExpr, ok := in.t.parseIfTag(text)
+				if !ok {
+					in.report("malformed tag %q, expected {{if condition}}", trimmed)
+					continue
+				}
+				end, err := in.t.findBlockEnd(items, i+1, "endif")
+				if err != nil {
+					in.report("%v", err)
+					continue
+				}
+				in.addField(scope, condExpr)
+				in.walkItems(items[i+1:end], base+i+1, scope, top)
+				in.endTags(items, end, base, top)
+				i = end
+				continue
+			}
+			if content, ok := in.t.delims.blockTag(trimmed); ok && (content == "endfor" || content == "endif") {
+				in.report("%s without a matching block start", trimmed)
+				continue
+			}
+			if name, ok := in.t.parseDefineTag(text); ok {
+				end, err := in.t.findDefineEnd(items, i+1)
+				if err != nil {
+					in.report("%v", err)
+					continue
+				}
+				in.macros[name] = true
+				loc := in.location()
+				inner := &Scope{Kind: "define", Variable: name, Location: &loc}
+				scope.Scopes = append(scope.Scopes, inner)
+				in.walkItems(items[i+1:end], base+i+1, inner, top)
+				in.endTags(items, end, base, top)
+				i = end
+				continue
+			}
+			if name, expr, ok := in.t.parseCallTag(text); ok && in.macros[name] {
+				if expr != "" {
+					in.addField(scope, expr)
+				}
+				continue
+			}
+			if expr, ok := in.t.parseIncludeTag(text); ok {
+				in.inspectInline(in.t.delims.left+" "+expr+" "+in.t.delims.right, scope)
+				continue
+			}
+			if name, expr, ok := in.t.parseSetTag(text); ok {
+				in.inspectInline(in.t.delims.left+" "+expr+" "+in.t.delims.right, scope)
+				in.vars[len(in.vars)-1][name] = true
+				scope.Bindings = append(scope.Bindings, name)
+				continue
+			}
+			in.inspectInline(text, scope)
+		case *docx.Table:
+			in.walkTable(it, base+i, scope)
+		}
+	}
+}
+
+func (in *inspector) walkTable(table *docx.Table, index int, scope *Scope) {
+	saved := in.loc
+	defer func() { in.loc = saved }()
+
+	depth := 0
+	for r, row := range table.TableRows {
+		rowScope := scope
+		for c, cell := range row.TableCells {
+			in.loc = saved
+			in.loc.Cells = append(append([]CellRef(nil), saved.Cells...), CellRef{Table: index, Row: r, Cell: c})
+			for _, p := range cell.Paragraphs {
+				text := in.t.getParagraphText(p)
+				if m := in.t.delims.tableIf.FindStringSubmatch(text); m != nil {
+					in.addField(scope, m[1])
+				}
+			}
+		}
+
+		if p, _, cmd, ok := in.t.findRowTag(row, "range"); ok {
+			in.atParagraph(saved, index, r, row, p)
+			in.addField(scope, cmd)
+			loc := in.location()
+			rowScope = &Scope{Kind: "range", Source: cmd, Location: &loc}
+			scope.Scopes = append(scope.Scopes, rowScope)
+		} else if p, _, cmd, ok := in.t.findRowTag(row, "if"); ok {
+			in.rowTags(saved, index, r, row)
+			in.atParagraph(saved, index, r, row, p)
+			in.addField(scope, cmd)
+			depth++
+			if in.t.findRowBlockEnd(table.TableRows, r+1) == -1 {
+				in.report("missing %s for %s", in.t.delims.tag("endif"), in.t.delims.tag("if "+cmd))
+			}
+			continue
+		} else if p, _, _, ok := in.t.findRowTag(row, "endif"); ok {
+			in.rowTags(saved, index, r, row)
+			in.atParagraph(saved, index, r, row, p)
+			if depth == 0 {
+				in.report("{{endif}} row without a matching {{if}} row")
+			} else {
+				depth--
+			}
+			continue
+		}
+
+		for c, cell := range row.TableCells {
+			in.loc = saved
+			in.loc.Cells = append(append([]CellRef(nil), saved.Cells...), CellRef{Table: index, Row: r, Cell: c})
+			in.walkItems(cellItems(cell), 0, rowScope, false)
+		}
+	}
+}
+
+// atParagraph points the location at paragraph p of row r of the table at
+// index, which is inside the cells of base
+func (in *inspector) atParagraph(base Location, index, r int, row *docx.WTableRow, p *docx.Paragraph) {
+	for c, cell := range row.TableCells {
+		for i, cp := range cell.Paragraphs {
+			if cp == p {
+				in.loc = base
+				in.loc.Cells = append(append([]CellRef(nil), base.Cells...), CellRef{Table: index, Row: r, Cell: c})
+				in.loc.Paragraph = i
+				in.loc.Text = in.t.getParagraphText(p)
+				return
+			}
+		}
+	}
+}
+
+// endTags records the tags of items[end], which ends a block and is skipped
+func (in *inspector) endTags(items []interface{}, end, base int, top bool) {
+	p, ok := items[end].(*docx.Paragraph)
+	if !ok {
+		return
+	}
+	if top {
+		in.loc.Item = base + end
+	}
+	in.loc.Paragraph = base + end
+	in.loc.Text = in.t.getParagraphText(p)
+	in.addTags(in.loc.Text)
+}
+
+// rowTags records the tags of row r, whose cells are not walked
+func (in *inspector) rowTags(base Location, index, r int, row *docx.WTableRow) {
+	for c, cell := range row.TableCells {
+		for i, p := range cell.Paragraphs {
+			in.loc = base
+			in.loc.Cells = append(append([]CellRef(nil), base.Cells...), CellRef{Table: index, Row: r, Cell: c})
+			in.loc.Paragraph = i
+			in.loc.Text = in.t.getParagraphText(p)
+			in.addTags(in.loc.Text)
+		}
+	}
+}
+
+// inspectInline parses the text/template actions of a paragraph
+func (in *inspector) inspectInline(text string, scope *Scope) {
+	d := in.t.delims
+	text = d.stripComments(d.escape(text))
+	text = d.layout.ReplaceAllString(text, "")
+	text = d.tableIf.ReplaceAllString(text, "")
+	if rangeTag, _, ok := in.t.parseRowTag(text, "range"); ok && !d.inlineEnd.MatchString(text) {
+		text = strings.Replace(text, rangeTag, "", 1)
+	}
+
+	if !strings.Contains(text, d.left) {
+		return
+	}
+
+	tree := parse.New("p")
+	tree.Mode = parse.SkipFuncCheck | parse.ParseComments
+	if _, err := tree.Parse(text, d.left, d.right, map[string]*parse.Tree{}); err != nil {
+		in.report("malformed tag: %v", err)
+		return
+	}
+	in.walkNode(tree.Root, scope, nil)
+}
+
+// walkNode records the references made by n. dot is the field path the
+// template dot refers to, nil for the scope itself.
+func (in *inspector) walkNode(n parse.Node, scope *Scope, dot []string) {
+	switch n := n.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			in.walkNode(c, scope, dot)
+		}
+	case *parse.ActionNode:
+		in.walkPipe(n.Pipe, scope, dot)
+	case *parse.IfNode:
+		in.walkPipe(n.Pipe, scope, dot)
+		in.walkNode(n.List, scope, dot)
+		in.walkNode(n.ElseList, scope, dot)
+	case *parse.RangeNode:
+		in.walkPipe(n.Pipe, scope, dot)
+		in.walkNode(n.List, scope, in.pipeDot(n.Pipe, dot))
+		in.walkNode(n.ElseList, scope, dot)
+	case *parse.WithNode:
+		in.walkPipe(n.Pipe, scope, dot)
+		in.walkNode(n.List, scope, in.pipeDot(n.Pipe, dot))
+		in.walkNode(n.ElseList, scope, dot)
+	case *parse.TemplateNode:
+		in.walkPipe(n.Pipe, scope, dot)
+	}
+}
+
+// pipeDot returns the field path a range or with pipeline moves the dot to
+func (in *inspector) pipeDot(pipe *parse.PipeNode, dot []string) []string {
+	if pipe == nil || len(pipe.Cmds) != 1 || len(pipe.Cmds[0].Args) != 1 {
+		return dot
+	}
+	if f, ok := pipe.Cmds[0].Args[0].(*parse.FieldNode); ok {
+		return append(append([]string(nil), dot...), f.Ident...)
+	}
+	return dot
+}
+
+func (in *inspector) walkPipe(pipe *parse.PipeNode, scope *Scope, dot []string) {
+	if pipe == nil {
+		return
+	}
+	for _, cmd := range pipe.Cmds {
+		for i, arg := range cmd.Args {
+			switch a := arg.(type) {
+			case *parse.FieldNode:
+				in.addPath(scope, append(append([]string(nil), dot...), a.Ident...))
+			case *parse.ChainNode:
+				if id, ok := a.Node.(*parse.IdentifierNode); ok && !in.isFunc(id.Ident) {
+					if in.bound(id.Ident) {
+						continue
+					}
+					in.addPath(scope, append([]string{id.Ident}, a.Field...))
+				} else {
+					in.walkArg(a.Node, scope, dot)
+				}
+			case *parse.IdentifierNode:
+				if in.isFunc(a.Ident) {
+					if a.Ident == "inject" && i == 0 && len(cmd.Args) > 1 {
+						scope.Injectors = append(scope.Injectors, Slot{
+							Expr:     cmd.Args[1].String(),
+							Location: in.location(),
+						})
+					}
+					continue
+				}
+				if i == 0 && len(cmd.Args) > 1 {
+					in.report("function %q not defined", a.Ident)
+					continue
+				}
+				// Bare identifiers name top-level data keys or {{set}} bindings
+				if !in.bound(a.Ident) {
+					in.addPath(scope, []string{a.Ident})
+				}
+			default:
+				in.walkArg(arg, scope, dot)
+			}
+		}
+	}
+}
+
+func (in *inspector) walkArg(n parse.Node, scope *Scope, dot []string) {
+	if p, ok := n.(*parse.PipeNode); ok {
+		in.walkPipe(p, scope, dot)
+	}
+}
+
+var builtinFuncs = []string{
+	"and", "call", "html", "index", "slice", "js", "len", "not", "or",
+	"print", "printf", "println", "urlquery", "eq", "ge", "gt", "le", "lt", "ne",
+}
+
+func (in *inspector) isFunc(name string) bool {
+	if _, ok := in.t.funcs[name]; ok {
+		return true
+	}
+	if _, ok := in.t.styleFuncs()[name]; ok || name == "inject" {
+		return true
+	}
+	for _, f := range builtinFuncs {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
+
+// addField records a block expression such as vuln.Name or .Vulns
+func (in *inspector) addField(scope *Scope, expr string) {
+	expr = strings.TrimSpace(expr)
+	if name, _, _ := strings.Cut(expr, "."); in.bound(name) {
+		return
+	}
+	in.addPath(scope, strings.Split(strings.TrimPrefix(strings.TrimSpace(expr), "."), "."))
+}
+
+func (in *inspector) addPath(scope *Scope, path []string) {
+	fields := &scope.Fields
+	for _, name := range path {
+		if name == "" {
+			continue
+		}
+		var f *Field
+		for _, existing := range *fields {
+			if existing.Name == name {
+				f = existing
+				break
+			}
+		}
+		if f == nil {
+			f = &Field{Name: name}
+			*fields = append(*fields, f)
+		}
+		fields = &f.Children
+	}
+}
+
+// bound reports whether name is bound by {{set}} in the current scope
+func (in *inspector) bound(name string) bool {
+	for _, frame := range in.vars {
+		if frame[name] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,167 @@
+package docxexp
+
+import (
+	"bytes"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// fieldPaths returns the dotted paths of fields and their children
+func fieldPaths(fields []*Field) []string {
+	var out []string
+	for _, f := range fields {
+		out = append(out, f.Name)
+		for _, child := range fieldPaths(f.Children) {
+			out = append(out, f.Name+"."+child)
+		}
+	}
+	return out
+}
+
+// scopeString returns scope and the scopes inside it as
+// kind variable source [fields] [injectors] {scopes}
+func scopeString(s *Scope) string {
+	var injectors []string
+	for _, slot := range s.Injectors {
+		injectors = append(injectors, slot.Expr)
+	}
+	var inner []string
+	for _, c := range s.Scopes {
+		inner = append(inner, scopeString(c))
+	}
+	return fmt.Sprintf("%s %s %s %v %v {%s}", s.Kind, s.Variable, s.Source, fieldPaths(s.Fields), injectors, strings.Join(inner, ", "))
+}
+
+func TestInspect(t *testing.T) {
+	tpl := newTestTemplate(t, func(doc *docx.Docx) {
+		for _, line := range []string{
+			"{{ .Title }}",
+			"{{set n = len .Items}}",
+			"{{for v in .Items}}",
+			"{{ v.Name }} {{ n }} {{ inject .Logo }}",
+			"{{endfor}}",
+			"{{define box}}",
+			"{{ .Label }}",
+			"{{enddefine}}",
+		} {
+			doc.AddParagraph().AddText(line)
+		}
+		tbl := doc.AddTable(2, 2, 0, nil)
+		tbl.TableRows[0].TableCells[1].AddParagraph().AddText("{{tableif .Show}}")
+		tbl.TableRows[1].TableCells[0].AddParagraph().AddText("{{ range .Rows }}{{ .Cost }}")
+	})
+	in := tpl.Inspect()
+	want := "root   [Title Items Show Rows] [] {" +
+		"for v .Items [v v.Name Logo] [.Logo] {}, " +
+		"define box  [Label] [] {}, " +
+		"range  .Rows [Cost] [] {}}"
+	if got := scopeString(in.Root); got != want {
+		t.Errorf("scopes =\n%s\nwant\n%s", got, want)
+	}
+	if len(in.Diagnostics) != 0 {
+		t.Errorf("diagnostics = %v", in.Diagnostics)
+	}
+
+	// Tags are listed in document order with their location
+	var tags []string
+	for _, tag := range in.Tags {
+		tags = append(tags, tag.Location.String()+" "+tag.Text)
+	}
+	wantTags := []string{
+		"word/document.xml item 0 paragraph 0 {{ .Title }}",
+		"word/document.xml item 1 paragraph 1 {{set n = len .Items}}",
+		"word/document.xml item 2 paragraph 2 {{for v in .Items}}",
+		"word/document.xml item 3 paragraph 3 {{ v.Name }}",
+		"word/document.xml item 3 paragraph 3 {{ n }}",
+		"word/document.xml item 3 paragraph 3 {{ inject .Logo }}",
+		"word/document.xml item 4 paragraph 4 {{endfor}}",
+		"word/document.xml item 5 paragraph 5 {{define box}}",
+		"word/document.xml item 6 paragraph 6 {{ .Label }}",
+		"word/document.xml item 7 paragraph 7 {{enddefine}}",
+		"word/document.xml item 8 table 8 row 0 cell 1 paragraph 0 {{tableif .Show}}",
+		"word/document.xml item 8 table 8 row 1 cell 0 paragraph 0 {{ range .Rows }}",
+		"word/document.xml item 8 table 8 row 1 cell 0 paragraph 0 {{ .Cost }}",
+	}
+	if !slices.Equal(tags, wantTags) {
+		t.Errorf("tags =\n%s\nwant\n%s", strings.Join(tags, "\n"), strings.Join(wantTags, "\n"))
+	}
+}
+
+func TestLint(t *testing.T) {
+	footer := `<w:ftr xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"><w:p><w:r><w:t>{{ .Page }}</w:t></w:r></w:p></w:ftr>`
+	data := packageOf(t, func(doc *docx.Docx) {
+		for _, line := range []string{"{{ nope .X }}", "{{ .A", "{{endfor}}", "{{for v in}}", "{{if .B}}"} {
+			doc.AddParagraph().AddText(line)
+		}
+	})
+	var buf bytes.Buffer
+	err := rewritePackage(data, &buf, map[string]func([]byte) ([]byte, error){
+		"word/footer1.xml": func([]byte) ([]byte, error) { return []byte(footer), nil },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, d := range tpl.Lint() {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`word/document.xml item 0 paragraph 0: function "nope" not defined`,
+		`word/document.xml item 1 paragraph 1: malformed tag`,
+		`word/document.xml item 2 paragraph 2: {{endfor}} without a matching block start`,
+		`word/document.xml item 3 paragraph 3: malformed tag "{{for v in}}"`,
+		`word/document.xml item 4 paragraph 4: block end {{endif}} not found`,
+		`word/footer1.xml item 0 paragraph 0: tags in word/footer1.xml are not rendered`,
+	}
+	if len(got) != len(want) {
+		t.Fatalf("diagnostics =\n%s", strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("diagnostic %d = %q, want %q...", i, got[i], want[i])
+		}
+	}
+}
+
+func TestReadStories(t *testing.T) {
+	const ns = `xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main"`
+	notes := `<w:footnotes ` + ns + `>` +
+		`<w:footnote w:id="1"><w:p><w:r><w:t>a</w:t></w:r></w:p><w:p><w:r><w:t>b</w:t></w:r></w:p></w:footnote>` +
+		`<w:footnote w:id="2"><w:tbl><w:tr><w:tc><w:p><w:r><w:t>c</w:t></w:r></w:p></w:tc></w:tr></w:tbl>` +
+		`<w:p><w:unknown/><w:r><w:t>d</w:t></w:r></w:p></w:footnote>` +
+		`</w:footnotes>`
+	stories, err := readStories([]byte(notes))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl := &DocxTemplate{}
+	var got []string
+	for _, items := range stories {
+		var story []string
+		for _, item := range items {
+			switch it := item.(type) {
+			case *docx.Paragraph:
+				story = append(story, tpl.getParagraphText(it))
+			case *docx.Table:
+				story = append(story, "table")
+			}
+		}
+		got = append(got, strings.Join(story, " "))
+	}
+	if want := []string{"a b", "table d"}; !slices.Equal(got, want) {
+		t.Errorf("stories = %q, want %q", got, want)
+	}
+
+	if _, err := readStories([]byte(`<w:footnotes ` + ns + `><w:footnote><w:p></w:footnote>`)); err == nil {
+		t.Error("read malformed XML")
+	}
+}
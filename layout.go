@@ -0,0 +1,272 @@
+package docxexp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// layoutMarker carries a row or paragraph property that go-docx cannot
+// represent. It is stored among the paragraph children, written as an XML
+// comment and turned into the real property when the document is saved.
+type layoutMarker struct {
+	// target is the element receiving the property: "tr" or "p"
+	target string
+	// prop is the property element name, such as "tblHeader"
+	prop string
+}
+
+// MarshalXML implements xml.Marshaler
+func (m *layoutMarker) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	return e.EncodeToken(xml.Comment(" docxexp:" + m.target + ":" + m.prop + " "))
+}
+
+// RepeatHeader marks row as a header row repeated at the top of each page
+func RepeatHeader(row *docx.WTableRow) {
+	markRow(row, "tblHeader")
+}
+
+// CantSplit prevents row from being split across pages
+func CantSplit(row *docx.WTableRow) {
+	markRow(row, "cantSplit")
+}
+
+// KeepNext keeps p on the same page as the item following it
+func KeepNext(p *docx.Paragraph) {
+	markParagraph(p, &layoutMarker{target: "p", prop: "keepNext"})
+}
+
+// KeepLines keeps all lines of p on the same page
+func KeepLines(p *docx.Paragraph) {
+	markParagraph(p, &layoutMarker{target: "p", prop: "keepLines"})
+}
+
+func markRow(row *docx.WTableRow, prop string) {
+	if len(row.TableCells) == 0 {
+		return
+	}
+	// The marker goes in the first paragraph of the row that is not the
+	// slot of a nested table
+	cell := row.TableCells[0]
+	for _, p := range cell.Paragraphs {
+		if !isSlot(p) {
+			markParagraph(p, &layoutMarker{target: "tr", prop: prop})
+			return
+		}
+	}
+	p := &docx.Paragraph{}
+	cell.Paragraphs = append(cell.Paragraphs, p)
+	markParagraph(p, &layoutMarker{target: "tr", prop: prop})
+}
+
+func markParagraph(p *docx.Paragraph, m *layoutMarker) {
+	for _, child := range p.Children {
+		if lm, ok := child.(*layoutMarker); ok && *lm == *m {
+			return
+		}
+	}
+	p.Children = append(p.Children, m)
+}
+
+// takeDirectives removes the layout directives {{repeatheader}}, {{cantsplit}},
+// {{keepnext}}, {{keeplines}} and {{caption}} from p and returns their names
+func (t *DocxTemplate) takeDirectives(p *docx.Paragraph) []string {
+	text := t.getParagraphText(p)
+	matches := t.delims.layout.FindAllStringSubmatch(text, -1)
+	if matches == nil {
+		return nil
+	}
+	var names []string
+	for _, m := range matches {
+		names = append(names, m[1])
+	}
+	t.replaceTextInParagraph(p, text, t.delims.layout.ReplaceAllString(text, ""))
+	return names
+}
+
+// applyRowDirectives handles the layout directives found in any cell of row
+func (t *DocxTemplate) applyRowDirectives(row *docx.WTableRow) {
+	for _, cell := range row.TableCells {
+		for _, p := range cell.Paragraphs {
+			for _, name := range t.takeDirectives(p) {
+				switch name {
+				case "repeatheader":
+					RepeatHeader(row)
+				case "cantsplit":
+					CantSplit(row)
+				case "keepnext":
+					KeepNext(p)
+				case "keeplines":
+					KeepLines(p)
+				}
+			}
+		}
+	}
+}
+
+// applyParagraphDirectives handles the layout directives found in p
+func (t *DocxTemplate) applyParagraphDirectives(p *docx.Paragraph) {
+	for _, name := range t.takeDirectives(p) {
+		switch name {
+		case "keepnext":
+			KeepNext(p)
+		case "keeplines":
+			KeepLines(p)
+		case "caption":
+			t.captions[p] = true
+		}
+	}
+}
+
+// isCaption reports whether p is the caption of the table after it: it is
+// marked with {{caption}} or has the Caption style
+func (t *DocxTemplate) isCaption(p *docx.Paragraph) bool {
+	if t.captions[p] {
+		return true
+	}
+	if p.Properties == nil || p.Properties.Style == nil {
+		return false
+	}
+	id, ok := t.styleID("caption")
+	return ok && p.Properties.Style.Val == id
+}
+
+var layoutTokenRe = regexp.MustCompile(`<w:tr[ >]|</w:tr>|<w:p[ >]|</w:p>|<!-- docxexp:(tr|p):(\w+) -->`)
+
+// applyLayoutMarkers replaces the layout marker comments in document.xml
+// with the properties they stand for on their enclosing row or paragraph.
+func applyLayoutMarkers(data []byte) ([]byte, error) {
+	if !bytes.Contains(data, []byte("<!-- docxexp:")) {
+		return data, nil
+	}
+
+	type edit struct {
+		start, end int
+		text       string
+	}
+	var edits []edit
+	var rows, paras []int
+	props := make(map[int][]string)
+	var starts []int
+	for _, m := range layoutTokenRe.FindAllSubmatchIndex(data, -1) {
+		tok := string(data[m[0]:m[1]])
+		switch {
+		case strings.HasPrefix(tok, "<w:tr"):
+			rows = append(rows, m[0])
+		case tok == "</w:tr>":
+			if len(rows) > 0 {
+				rows = rows[:len(rows)-1]
+			}
+		case strings.HasPrefix(tok, "<w:p"):
+			paras = append(paras, m[0])
+		case tok == "</w:p>":
+			if len(paras) > 0 {
+				paras = paras[:len(paras)-1]
+			}
+		default:
+			stack := paras
+			if string(data[m[2]:m[3]]) == "tr" {
+				stack = rows
+			}
+			if len(stack) > 0 {
+				start := stack[len(stack)-1]
+				if _, ok := props[start]; !ok {
+					starts = append(starts, start)
+				}
+				props[start] = append(props[start], string(data[m[4]:m[5]]))
+			}
+			edits = append(edits, edit{start: m[0], end: m[1]})
+		}
+	}
+
+	for _, start := range starts {
+		container := "w:pPr"
+		if bytes.HasPrefix(data[start:], []byte("<w:tr")) {
+			container = "w:trPr"
+		}
+		pos := start + bytes.IndexByte(data[start:], '>') + 1
+		open, end := "<"+container+">", pos
+		content := ""
+		if bytes.HasPrefix(data[pos:], []byte(open)) {
+			n := bytes.Index(data[pos:], []byte("</"+container+">"))
+			content = string(data[pos+len(open) : pos+n])
+			end = pos + n + len("</"+container+">")
+		}
+		for _, prop := range props[start] {
+			content = addProperty(content, prop)
+		}
+		edits = append(edits, edit{start: pos, end: end, text: open + content + "</" + container + ">"})
+	}
+	sort.Slice(edits, func(i, j int) bool {
+		if edits[i].start != edits[j].start {
+			return edits[i].start < edits[j].start
+		}
+		return edits[i].end < edits[j].end
+	})
+
+	var out bytes.Buffer
+	last := 0
+	for _, e := range edits {
+		out.Write(data[last:e.start])
+		out.WriteString(e.text)
+		last = e.end
+	}
+	out.Write(data[last:])
+	return out.Bytes(), nil
+}
+
+// propertiesBefore lists the elements that come before each layout property
+// in its w:pPr or w:trPr, following the order of the schema
+var propertiesBefore = map[string][]string{
+	"keepNext":  {"pStyle"},
+	"keepLines": {"pStyle", "keepNext"},
+	"cantSplit": {"cnfStyle", "divId", "gridBefore", "gridAfter", "wBefore", "wAfter"},
+	"tblHeader": {"cnfStyle", "divId", "gridBefore", "gridAfter", "wBefore", "wAfter", "cantSplit", "trHeight"},
+}
+
+// addProperty adds the empty element prop to content, the children of a
+// w:pPr or w:trPr, after the elements that come before it. A property that is
+// already set is left as it is.
+func addProperty(content, prop string) string {
+	if elementEnd(content, prop) >= 0 {
+		return content
+	}
+	pos := 0
+	for _, name := range propertiesBefore[prop] {
+		if end := elementEnd(content, name); end > pos {
+			pos = end
+		}
+	}
+	return content[:pos] + "<w:" + prop + "/>" + content[pos:]
+}
+
+// elementEnd returns the offset in content just after the element w:name,
+// or -1 if there is none
+func elementEnd(content, name string) int {
+	tag := "<w:" + name
+	for i := 0; ; {
+		j := strings.Index(content[i:], tag)
+		if j < 0 {
+			return -1
+		}
+		i += j + len(tag)
+		if i < len(content) && (content[i] == ' ' || content[i] == '/' || content[i] == '>') {
+			gt := strings.IndexByte(content[i:], '>')
+			if gt < 0 {
+				return -1
+			}
+			if content[i+gt-1] == '/' {
+				return i + gt + 1
+			}
+			closing := "</w:" + name + ">"
+			if k := strings.Index(content[i:], closing); k >= 0 {
+				return i + k + len(closing)
+			}
+			return -1
+		}
+	}
+}
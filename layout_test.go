@@ -0,0 +1,75 @@
+package docxexp
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestApplyLayoutMarkers(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{
+			"after the style",
+			`<w:p><w:pPr><w:pStyle w:val="Title"/><w:jc w:val="center"/></w:pPr><!-- docxexp:p:keepNext --><w:r></w:r></w:p>`,
+			`<w:p><w:pPr><w:pStyle w:val="Title"/><w:keepNext/><w:jc w:val="center"/></w:pPr><w:r></w:r></w:p>`,
+		},
+		{
+			"in schema order",
+			`<w:p><!-- docxexp:p:keepLines --><!-- docxexp:p:keepNext --></w:p>`,
+			`<w:p><w:pPr><w:keepNext/><w:keepLines/></w:pPr></w:p>`,
+		},
+		{
+			"already set",
+			`<w:p><w:pPr><w:keepNext/></w:pPr><!-- docxexp:p:keepNext --></w:p>`,
+			`<w:p><w:pPr><w:keepNext/></w:pPr></w:p>`,
+		},
+		{
+			"row",
+			`<w:tr><w:trPr><w:trHeight w:val="300"/></w:trPr><w:tc><w:p><!-- docxexp:tr:tblHeader --><!-- docxexp:tr:cantSplit --></w:p></w:tc></w:tr>`,
+			`<w:tr><w:trPr><w:cantSplit/><w:trHeight w:val="300"/><w:tblHeader/></w:trPr><w:tc><w:p></w:p></w:tc></w:tr>`,
+		},
+		{
+			"row without properties",
+			`<w:tr><w:tc><w:p><w:pPr></w:pPr><!-- docxexp:tr:cantSplit --></w:p></w:tc></w:tr>`,
+			`<w:tr><w:trPr><w:cantSplit/></w:trPr><w:tc><w:p><w:pPr></w:pPr></w:p></w:tc></w:tr>`,
+		},
+		{
+			"nested paragraphs",
+			`<w:p><!-- docxexp:p:keepNext --></w:p><w:p><w:pPr><w:pStyle w:val="A"/></w:pPr><!-- docxexp:p:keepLines --></w:p>`,
+			`<w:p><w:pPr><w:keepNext/></w:pPr></w:p><w:p><w:pPr><w:pStyle w:val="A"/><w:keepLines/></w:pPr></w:p>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyLayoutMarkers([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTableInjectorLayout(t *testing.T) {
+	tpl := textTemplate(t, "{{ inject .T }}")
+	table := TableInjector{Header: []string{"h"}, Rows: [][]string{{"a"}, {"b"}}, RepeatHeader: true, CantSplit: true}
+	if err := tpl.Render(map[string]interface{}{"T": table}); err != nil {
+		t.Fatal(err)
+	}
+	document := savedPart(t, tpl, documentPart)
+	if n := strings.Count(document, "<w:tblHeader/>"); n != 1 {
+		t.Errorf("%d header rows, want 1", n)
+	}
+	if n := strings.Count(document, "<w:cantSplit/>"); n != 3 {
+		t.Errorf("%d rows that cannot split, want 3", n)
+	}
+	if !strings.Contains(document, "<w:cantSplit/><w:tblHeader/>") {
+		t.Errorf("the header row properties are out of order:\n%s", document)
+	}
+	if strings.Contains(document, "docxexp:") {
+		t.Errorf("document.xml keeps layout markers:\n%s", document)
+	}
+}
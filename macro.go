@@ -0,0 +1,115 @@
+package docxexp
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// macro is a block of items defined by {{define name}} ... {{enddefine}}
+type macro struct {
+	items []interface{}
+	// base is the template index of the first item in its body or cell
+	base int
+}
+
+// maxCallDepth bounds the nesting of {{call}} so that recursive macros fail
+const maxCallDepth = 64
+
+// parseDefineTag parses a {{define name}} tag
+func (t *DocxTemplate) parseDefineTag(text string) (string, bool) {
+	content, ok := t.delims.blockTag(text)
+	if !ok || !strings.HasPrefix(content, "define ") {
+		return "", false
+	}
+	name := strings.TrimSpace(strings.TrimPrefix(content, "define "))
+	if !setNameRe.MatchString(name) {
+		return "", false
+	}
+	return name, true
+}
+
+// parseCallTag parses a {{call name expr}} tag. The expression is optional.
+// Tags naming no defined macro are left to the text/template call builtin.
+func (t *DocxTemplate) parseCallTag(text string) (string, string, bool) {
+	content, ok := t.delims.blockTag(text)
+	if !ok || !strings.HasPrefix(content, "call ") {
+		return "", "", false
+	}
+	name, expr, _ := strings.Cut(strings.TrimSpace(strings.TrimPrefix(content, "call ")), " ")
+	return name, strings.TrimSpace(expr), true
+}
+
+// hasMacro reports whether a block is defined as name
+func (t *DocxTemplate) hasMacro(name string) bool {
+	_, ok := t.macros[name]
+	return ok
+}
+
+// findDefineEnd returns the index of the {{enddefine}} closing the
+// definition whose body starts at start
+func (t *DocxTemplate) findDefineEnd(items []interface{}, start int) (int, error) {
+	for i := start; i < len(items); i++ {
+		if p, ok := items[i].(*docx.Paragraph); ok {
+			if content, ok := t.delims.blockTag(t.getParagraphText(p)); ok && content == "enddefine" {
+				return i, nil
+			}
+		}
+	}
+	return -1, &BlockError{Tag: "enddefine", Msg: fmt.Sprintf("block end %s not found", t.delims.tag("enddefine"))}
+}
+
+// collectMacros returns the macros defined at the top level of items so
+// that they can be called before their definition
+func (t *DocxTemplate) collectMacros(items []interface{}) map[string]macro {
+	macros := make(map[string]macro)
+	for i := 0; i < len(items); i++ {
+		p, ok := items[i].(*docx.Paragraph)
+		if !ok {
+			continue
+		}
+		name, ok := t.parseDefineTag(t.getParagraphText(p))
+		if !ok {
+			continue
+		}
+		end, err := t.findDefineEnd(items, i+1)
+		if err != nil {
+			// Reported when the definition is reached
+			break
+		}
+		macros[name] = macro{items: items[i+1 : end], base: i + 1}
+		i = end
+	}
+	return macros
+}
+
+// callMacro renders a clone of the macro name with the value of expr, or
+// with data when expr is empty, as the data
+func (t *DocxTemplate) callMacro(name, expr string, data interface{}) ([]interface{}, error) {
+	arg := data
+	if expr != "" {
+		val, err := t.evaluateExpression(expr, data)
+		if err != nil {
+			return nil, err
+		}
+		arg = val
+	}
+	if t.calls >= maxCallDepth {
+		return nil, &BlockError{Tag: "call", Msg: fmt.Sprintf("macro %s nested more than %d times", name, maxCallDepth)}
+	}
+
+	m := t.macros[name]
+	block, err := t.cloneBlock(m.items)
+	if err != nil {
+		return nil, err
+	}
+	t.calls++
+	saved := t.base
+	t.base = m.base
+	defer func() {
+		t.calls--
+		t.base = saved
+	}()
+	return t.traverseItems(block, arg)
+}
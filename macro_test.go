@@ -0,0 +1,71 @@
+package docxexp
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMacros(t *testing.T) {
+	data := map[string]interface{}{
+		"Name":     "Ann",
+		"Other":    "x",
+		"Findings": []map[string]string{{"Title": "a", "Severity": "high"}, {"Title": "b", "Severity": "low"}},
+	}
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{
+			"call before define",
+			[]string{"{{for f in .Findings}}", "{{call finding f}}", "{{endfor}}", "{{define finding}}", "{{ .Title }} ({{ .Severity }})", "{{enddefine}}"},
+			[]string{"a (high)", "b (low)"},
+		},
+		{
+			"call after define",
+			[]string{"{{define hello}}", "Hello {{ .Name }}", "{{enddefine}}", "start", "{{call hello}}"},
+			[]string{"start", "Hello Ann"},
+		},
+		{
+			"called twice",
+			[]string{"{{define line}}", "-{{ . }}-", "{{enddefine}}", "{{call line .Name}}", "{{call line .Other}}"},
+			[]string{"-Ann-", "-x-"},
+		},
+		{
+			"nested call",
+			[]string{"{{define outer}}", "<", "{{call inner}}", "{{enddefine}}", "{{define inner}}", "{{ .Name }}", "{{enddefine}}", "{{call outer}}"},
+			[]string{"<", "Ann"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			if err := tpl.Render(data); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMacroErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		lines []string
+	}{
+		{"unclosed define", []string{"{{define a}}", "x"}},
+		{"recursion", []string{"{{define a}}", "{{call a}}", "{{enddefine}}", "{{call a}}"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := textTemplate(t, tt.lines...).Render(nil)
+			var be *BlockError
+			if !errors.As(err, &be) {
+				t.Errorf("err = %v, want a BlockError", err)
+			}
+		})
+	}
+}
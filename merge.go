@@ -0,0 +1,322 @@
+package docxexp
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// MergeBreak selects what separates the documents joined by Merge
+type MergeBreak int
+
+const (
+	// SectionBreak starts each document in a section of its own, keeping
+	// its page setup, headers and footers
+	SectionBreak MergeBreak = iota
+	// PageBreak starts each document on a new page of the section of the
+	// first document
+	PageBreak
+)
+
+// MergeOptions controls how documents are merged
+type MergeOptions struct {
+	Break MergeBreak
+}
+
+var (
+	sectRefRe   = regexp.MustCompile(`r:id="([^"]*)"`)
+	relIDAttrRe = regexp.MustCompile(`\bId="([^"]*)"`)
+	relElemRe   = regexp.MustCompile(`<Relationship\b[^>]*>`)
+	targetRe    = regexp.MustCompile(`Target="([^"]*)"`)
+	typeElemRe  = regexp.MustCompile(`<(?:Override|Default)\b[^>]*>`)
+	partNameRe  = regexp.MustCompile(`PartName="([^"]*)"`)
+	extRe       = regexp.MustCompile(`Extension="([^"]*)"`)
+	ctRe        = regexp.MustCompile(`ContentType="([^"]*)"`)
+)
+
+// sectionMarker ends a section with the properties merged.sections[index].
+// It is stored among the children of an empty paragraph, written as an XML
+// comment and turned into the section properties when the document is saved.
+type sectionMarker struct {
+	index int
+}
+
+// MarshalXML implements xml.Marshaler
+func (m *sectionMarker) MarshalXML(e *xml.Encoder, _ xml.StartElement) error {
+	return e.EncodeToken(xml.Comment(fmt.Sprintf(" docxexp:sect:%d ", m.index)))
+}
+
+// Merge joins the bodies of docs, usually rendered, into a new template based
+// on the package of the first, starting each document in a section of its own
+func Merge(docs ...*DocxTemplate) (*DocxTemplate, error) {
+	return MergeWithOptions(MergeOptions{}, docs...)
+}
+
+// MergeWithOptions joins the bodies of docs into a new template based on the
+// package of the first. The first document keeps the images and hyperlinks
+// of that package under their IDs, while those it gained when it was
+// rendered and those of the other documents are copied under new IDs. So are
+// the numbering definitions of the other documents, and their styles when
+// an earlier document defines a different style with the same ID. go-docx
+// drops footnote references and bookmarks when it reads a document, so there
+// are none left to rename.
+func MergeWithOptions(opts MergeOptions, docs ...*DocxTemplate) (*DocxTemplate, error) {
+	if len(docs) == 0 {
+		return nil, errors.New("merge: no documents")
+	}
+	first := docs[0]
+	out, err := newTemplate(first.src)
+	if err != nil {
+		return nil, err
+	}
+	out.funcs = first.funcs
+	out.injectors = first.injectors
+	out.styles = first.styles
+	out.delims = first.delims
+	out.merged = first.merged.clone()
+	out.changed = true
+
+	var items []interface{}
+	var last interface{}
+	for i, doc := range docs {
+		body, sect := splitSection(doc.doc.Document.Body.Items)
+		raw, err := doc.rawSection()
+		if err != nil {
+			return nil, err
+		}
+		var adopted []interface{}
+		if i == 0 {
+			// The sections of a merged first document are copied with it,
+			// and the relationships of its package are those of out
+			a := out.newAdopter(doc, nil, nil, nil)
+			if a.shared, err = packageRelIDs(out); err == nil {
+				adopted, err = a.items(body)
+			}
+		} else {
+			out.merged.seq++
+			adopted, err = out.mergeDocument(doc, body)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("merge document %d: %w", i, err)
+		}
+		items = append(items, adopted...)
+
+		if opts.Break == PageBreak {
+			if i == 0 {
+				last = sect
+				out.merged.last = raw
+			}
+			if i < len(docs)-1 {
+				p := &docx.Paragraph{}
+				p.AddPageBreaks()
+				items = append(items, p)
+			}
+			continue
+		}
+		if i > 0 && raw != "" {
+			if raw, err = out.copySectionParts(doc, raw); err != nil {
+				return nil, fmt.Errorf("merge document %d: %w", i, err)
+			}
+		}
+		if i == len(docs)-1 {
+			last = sect
+			out.merged.last = raw
+			continue
+		}
+		if raw == "" {
+			raw = "<w:sectPr></w:sectPr>"
+		}
+		items = append(items, &docx.Paragraph{Children: []interface{}{&sectionMarker{index: len(out.merged.sections)}}})
+		out.merged.sections = append(out.merged.sections, raw)
+	}
+	if last != nil {
+		items = append(items, last)
+	}
+	out.doc.Document.Body.Items = items
+	return out, nil
+}
+
+// mergeDocument copies the body items of doc into the merged document t
+func (t *DocxTemplate) mergeDocument(doc *DocxTemplate, items []interface{}) ([]interface{}, error) {
+	numIDs, err := t.mergeNumbering(doc)
+	if err != nil {
+		return nil, err
+	}
+	styleIDs, err := t.mergeStyles(doc, numIDs)
+	if err != nil {
+		return nil, err
+	}
+	return t.adoptItems(doc, items, numIDs, styleIDs, func(k int) (int, error) {
+		raw, err := t.copySectionParts(doc, doc.merged.sections[k])
+		if err != nil {
+			return 0, err
+		}
+		t.merged.sections = append(t.merged.sections, raw)
+		return len(t.merged.sections) - 1, nil
+	})
+}
+
+// packageRelIDs returns the IDs of the document relationships in the package
+// t was read from
+func packageRelIDs(t *DocxTemplate) (map[string]bool, error) {
+	rels, err := readPart(t.pkg, relsPart)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]bool)
+	for _, elem := range relElemRe.FindAll(rels, -1) {
+		if m := relIDAttrRe.FindSubmatch(elem); m != nil {
+			ids[string(m[1])] = true
+		}
+	}
+	return ids, nil
+}
+
+// splitSection separates the body items from the section properties that
+// end the body, if any
+func splitSection(items []interface{}) ([]interface{}, interface{}) {
+	if n := len(items); n > 0 {
+		if sect, ok := items[n-1].(*docx.SectPr); ok {
+			return items[:n-1], sect
+		}
+	}
+	return items, nil
+}
+
+// rawSection returns the properties of the last section of the template as
+// they are written in its package, with the header and footer references
+// that go-docx drops
+func (t *DocxTemplate) rawSection() (string, error) {
+	if t.merged.last != "" {
+		return t.merged.last, nil
+	}
+	data, err := readPart(t.pkg, documentPart)
+	if err != nil {
+		return "", err
+	}
+	start := bytes.LastIndex(data, []byte("<w:sectPr"))
+	if start < 0 {
+		return "", nil
+	}
+	end := bytes.Index(data[start:], []byte("</w:sectPr>"))
+	if end < 0 {
+		end = bytes.Index(data[start:], []byte("/>"))
+		if end < 0 {
+			return "", nil
+		}
+		return string(data[start : start+end+len("/>")]), nil
+	}
+	return string(data[start : start+end+len("</w:sectPr>")]), nil
+}
+
+// copySectionParts copies the headers and footers referenced by the section
+// properties raw of doc and returns raw referring to the copies
+func (t *DocxTemplate) copySectionParts(doc *DocxTemplate, raw string) (string, error) {
+	rels := make(map[string]docx.Relationship)
+	doc.doc.RangeRelationships(func(r *docx.Relationship) error {
+		rels[r.ID] = *r
+		return nil
+	})
+	copied := make(map[string]string)
+	var err error
+	raw = sectRefRe.ReplaceAllStringFunc(raw, func(ref string) string {
+		rid := sectRefRe.FindStringSubmatch(ref)[1]
+		if id, ok := copied[rid]; ok {
+			return `r:id="` + id + `"`
+		}
+		rel, ok := rels[rid]
+		// Documents merged before refer to their copies by placeholder
+		if ph := relPlaceholderRe.FindStringSubmatch(rid); ph != nil {
+			k, _ := strconv.Atoi(ph[1])
+			if ok = k < len(doc.merged.rels); ok {
+				rel = docx.Relationship{Type: doc.merged.rels[k].typ, Target: doc.merged.rels[k].target}
+			}
+		}
+		if !ok || err != nil {
+			return ref
+		}
+		var target string
+		if target, err = t.copyPart(doc, rel.Target); err != nil {
+			return ref
+		}
+		id := relPlaceholder(len(t.merged.rels))
+		t.merged.rels = append(t.merged.rels, relationship{typ: rel.Type, target: target})
+		copied[rid] = id
+		return `r:id="` + id + `"`
+	})
+	return raw, err
+}
+
+// copyPart copies the part target of doc, relative to word/, and the parts
+// its relationships point to into the package under names prefixed for the
+// document being merged, and returns the target of the copy
+func (t *DocxTemplate) copyPart(doc *DocxTemplate, target string) (string, error) {
+	dir, base := path.Split(target)
+	copyTarget := fmt.Sprintf("%sp%d_%s", dir, t.merged.seq, base)
+	name, copyName := "word/"+target, "word/"+copyTarget
+	if _, ok := t.merged.parts[copyName]; ok {
+		return copyTarget, nil
+	}
+	data, err := doc.packagePart(name)
+	if err != nil {
+		return "", err
+	}
+	if data == nil {
+		return "", fmt.Errorf("part %s not found", name)
+	}
+	types, err := doc.packagePart(typesPart)
+	if err != nil {
+		return "", err
+	}
+	ct, byExt := contentType(types, name)
+	t.merged.addPart(copyName, data, ct, byExt)
+
+	rels, err := doc.packagePart("word/" + dir + "_rels/" + base + ".rels")
+	if err != nil || rels == nil {
+		return copyTarget, err
+	}
+	rels = relElemRe.ReplaceAllFunc(rels, func(elem []byte) []byte {
+		m := targetRe.FindSubmatch(elem)
+		if m == nil || err != nil || bytes.Contains(elem, []byte(`TargetMode="External"`)) {
+			return elem
+		}
+		var copied string
+		if copied, err = t.copyPart(doc, path.Join(dir, string(m[1]))); err != nil {
+			return elem
+		}
+		rel := strings.TrimPrefix(copied, dir)
+		return bytes.Replace(elem, m[0], []byte(`Target="`+rel+`"`), 1)
+	})
+	if err != nil {
+		return "", err
+	}
+	t.merged.addPart("word/"+dir+"_rels/"+path.Base(copyTarget)+".rels", rels, "", false)
+	return copyTarget, nil
+}
+
+// contentType returns the content type of the part name in the content types
+// part types, and whether it is given by the extension of name
+func contentType(types []byte, name string) (string, bool) {
+	ext := strings.TrimPrefix(path.Ext(name), ".")
+	var def string
+	for _, elem := range typeElemRe.FindAll(types, -1) {
+		ct := ctRe.FindSubmatch(elem)
+		if ct == nil {
+			continue
+		}
+		if m := partNameRe.FindSubmatch(elem); m != nil && string(m[1]) == "/"+name {
+			return string(ct[1]), false
+		}
+		if m := extRe.FindSubmatch(elem); m != nil && strings.EqualFold(string(m[1]), ext) {
+			def = string(ct[1])
+		}
+	}
+	return def, true
+}
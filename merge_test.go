@@ -0,0 +1,174 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// renderedList returns listPackage rendered with data
+func renderedList(t *testing.T, styleID, color string, data interface{}, lines ...string) *DocxTemplate {
+	t.Helper()
+	pkg := listPackage(t, styleID, color, lines...)
+	tpl, err := New(bytes.NewReader(pkg), int64(len(pkg)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tpl.Render(data); err != nil {
+		t.Fatal(err)
+	}
+	return tpl
+}
+
+func TestMerge(t *testing.T) {
+	tests := []struct {
+		name  string
+		brk   MergeBreak
+		texts []string
+		// sections and pages are the numbers of section properties and page
+		// breaks in the merged document, whose parts end without a section
+		sections, pages int
+	}{
+		{"sections", SectionBreak, []string{"a", "", "b", "", "c"}, 2, 0},
+		{"pages", PageBreak, []string{"a", "", "b", "", "c"}, 0, 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var docs []*DocxTemplate
+			for _, name := range []string{"a", "b", "c"} {
+				docs = append(docs, renderedList(t, "List", "FF0000", map[string]string{"Name": name}, "{{ .Name }}"))
+			}
+			out, err := MergeWithOptions(MergeOptions{Break: tt.brk}, docs...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(out); !slices.Equal(got, tt.texts) {
+				t.Errorf("texts = %q, want %q", got, tt.texts)
+			}
+			document := savedPart(t, out, documentPart)
+			if n := strings.Count(document, "<w:sectPr"); n != tt.sections {
+				t.Errorf("%d sections, want %d", n, tt.sections)
+			}
+			if n := strings.Count(document, `w:type="page"`); n != tt.pages {
+				t.Errorf("%d page breaks, want %d", n, tt.pages)
+			}
+		})
+	}
+}
+
+func TestMergeNumberingAndStyles(t *testing.T) {
+	first := renderedList(t, "Main", "FF0000", nil, "first")
+	second := renderedList(t, "Main", "0000FF", nil, "second")
+	third := renderedList(t, "Other", "00FF00", nil, "third")
+	out, err := MergeWithOptions(MergeOptions{Break: PageBreak}, first, second, third)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var ids []string
+	for _, id := range numIDs(out) {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	// Each document keeps a list of its own
+	if want := []string{"1", "2", "3"}; !slices.Equal(ids, want) {
+		t.Errorf("numbering IDs = %q, want %q", ids, want)
+	}
+	numbering := savedPart(t, out, numberingPart)
+	for _, def := range []string{
+		`<w:num w:numId="2"><w:abstractNumId w:val="1"/>`,
+		`<w:num w:numId="3"><w:abstractNumId w:val="2"/>`,
+	} {
+		if !strings.Contains(numbering, def) {
+			t.Errorf("numbering.xml lacks %s", def)
+		}
+	}
+
+	if got, want := paragraphStyles(out), []string{"Main", "Main1", "Other"}; !slices.Equal(got, want) {
+		t.Errorf("paragraph styles = %q, want %q", got, want)
+	}
+	styles := savedPart(t, out, stylesPart)
+	tests := []struct{ id, color string }{
+		{"Main", "FF0000"},
+		{"Main1", "0000FF"},
+		{"Other", "00FF00"},
+	}
+	for _, tt := range tests {
+		if got := styleColor(styles, tt.id); got != tt.color {
+			t.Errorf("style %s color = %q, want %q", tt.id, got, tt.color)
+		}
+	}
+
+	// Merging a merged document goes on numbering after its lists
+	again, err := MergeWithOptions(MergeOptions{Break: PageBreak}, out, renderedList(t, "Main", "FF0000", nil, "fourth"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := savedPart(t, again, numberingPart); !strings.Contains(got, `<w:num w:numId="4"><w:abstractNumId w:val="3"/>`) {
+		t.Errorf("numbering.xml of the second merge lacks list 4:\n%s", got)
+	}
+}
+
+var embedRe = regexp.MustCompile(`r:embed="([^"]*)"`)
+
+func TestMergeImages(t *testing.T) {
+	img, err := os.ReadFile("testdata/test_image.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg := packageOf(t, func(doc *docx.Docx) {
+		if _, err := doc.AddParagraph().AddInlineDrawing(img); err != nil {
+			t.Fatal(err)
+		}
+		doc.AddParagraph().AddText("{{ inject .Image }}")
+	})
+	var docs []*DocxTemplate
+	for range 2 {
+		tpl, err := New(bytes.NewReader(pkg), int64(len(pkg)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tpl.Render(map[string]interface{}{"Image": drawingInjector(img)}); err != nil {
+			t.Fatal(err)
+		}
+		docs = append(docs, tpl)
+	}
+	first := embedRe.FindAllString(savedPart(t, docs[0], documentPart), -1)
+	out, err := Merge(docs...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Every image is stored once and referenced, the images of the first
+	// document under the IDs it had
+	var buf bytes.Buffer
+	if err := out.Save(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	media := 0
+	for _, f := range zr.File {
+		if strings.HasPrefix(f.Name, "word/media/") {
+			media++
+		}
+	}
+	embeds := embedRe.FindAllString(savedPart(t, out, documentPart), -1)
+	if media != 4 || len(embeds) != 4 {
+		t.Errorf("%d media files and %d images, want 4", media, len(embeds))
+	}
+	if !slices.Equal(embeds[:2], first) {
+		t.Errorf("first images = %q, want %q", embeds[:2], first)
+	}
+	if ids := slices.Compact(slices.Sorted(slices.Values(embeds))); len(ids) != 4 {
+		t.Errorf("images %q share IDs", embeds)
+	}
+}
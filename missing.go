@@ -0,0 +1,254 @@
+package docxexp
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// MissingKey selects how a value missing from the render data is handled
+type MissingKey int
+
+const (
+	// MissingKeyDefault fails block tags and prints "<no value>" for inline actions
+	MissingKeyDefault MissingKey = iota
+	// MissingKeyError fails the render
+	MissingKeyError
+	// MissingKeyEmpty renders missing values as empty: loops run zero times
+	// and conditions are false
+	MissingKeyEmpty
+	// MissingKeyVerbatim leaves the tag as it is written in the template
+	MissingKeyVerbatim
+	// MissingKeyFunc asks RenderOptions.OnMissingKey for the value
+	MissingKeyFunc
+)
+
+// errVerbatim tells a block tag to leave its items unrendered
+var errVerbatim = errors.New("missing value left verbatim")
+
+// fieldFunc is the template function that inline field references are
+// rewritten to when a missing-key policy is set
+const fieldFunc = "__field"
+
+// missingValue applies the missing-key policy to expr, whose lookup failed with err
+func (t *DocxTemplate) missingValue(expr string, err error) (interface{}, error) {
+	switch t.opts.MissingKey {
+	case MissingKeyEmpty:
+		return nil, nil
+	case MissingKeyVerbatim:
+		return nil, errVerbatim
+	case MissingKeyFunc:
+		if t.opts.OnMissingKey != nil {
+			return t.opts.OnMissingKey(expr, t.location())
+		}
+	}
+	return nil, err
+}
+
+// parseInline parses the inline actions of text into tmpl. With a missing-key
+// policy or field options set, field references are rewritten to calls of
+// fieldFunc so that they also apply to inline actions. keys are the data keys
+// registered as template functions.
+func (t *DocxTemplate) parseInline(tmpl *template.Template, text string, keys map[string]interface{}) (*template.Template, error) {
+	if t.opts.MissingKey == MissingKeyDefault && !t.opts.fieldOptions() {
+		return tmpl.Parse(text)
+	}
+
+	trees := map[string]*parse.Tree{}
+	tree := parse.New(tmpl.Name())
+	tree.Mode = parse.SkipFuncCheck
+	if _, err := tree.Parse(text, t.delims.left, t.delims.right, trees); err != nil {
+		return nil, err
+	}
+	g := &fieldGuard{t: t, text: text, keys: keys}
+	for _, tr := range trees {
+		g.walk(tr.Root)
+		if _, err := tmpl.AddParseTree(tr.Name, tr); err != nil {
+			return nil, err
+		}
+	}
+
+	tmpl.Funcs(template.FuncMap{fieldFunc: t.inlineField})
+	return tmpl.Lookup(tmpl.Name()), nil
+}
+
+// inlineField resolves path from base for a rewritten inline action. action
+// is the text of the enclosing action when the reference is all it prints.
+func (t *DocxTemplate) inlineField(base interface{}, path, action string) (interface{}, error) {
+	val, omitted, err := t.lookup(base, path)
+	if omitted && action != "" {
+		// Empty fields omitted with OmitEmpty print nothing
+		return "", nil
+	}
+	if err == nil {
+		return val, nil
+	}
+	switch t.opts.MissingKey {
+	case MissingKeyDefault:
+		// As text/template: missing map keys print "<no value>"
+		var fe *FieldError
+		if errors.As(err, &fe) && fe.inMap {
+			return nil, nil
+		}
+		return nil, err
+	case MissingKeyEmpty:
+		if action != "" {
+			return "", nil
+		}
+		return nil, nil
+	case MissingKeyVerbatim:
+		if action != "" {
+			return action, nil
+		}
+		return nil, nil
+	}
+	return t.missingValue(path, err)
+}
+
+// fieldGuard rewrites the field references of a parsed paragraph
+type fieldGuard struct {
+	t    *DocxTemplate
+	text string
+	keys map[string]interface{}
+}
+
+func (g *fieldGuard) walk(node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, c := range n.Nodes {
+			g.walk(c)
+		}
+	case *parse.ActionNode:
+		action := ""
+		if len(n.Pipe.Decl) == 0 && len(n.Pipe.Cmds) == 1 && len(n.Pipe.Cmds[0].Args) == 1 {
+			action = g.actionText(n)
+		}
+		g.pipe(n.Pipe, action)
+	case *parse.IfNode:
+		g.branch(&n.BranchNode)
+	case *parse.RangeNode:
+		g.branch(&n.BranchNode)
+	case *parse.WithNode:
+		g.branch(&n.BranchNode)
+	case *parse.TemplateNode:
+		g.pipe(n.Pipe, "")
+	}
+}
+
+func (g *fieldGuard) branch(b *parse.BranchNode) {
+	g.pipe(b.Pipe, "")
+	g.walk(b.List)
+	g.walk(b.ElseList)
+}
+
+// actionText returns the text of the action n as written in the template
+func (g *fieldGuard) actionText(n *parse.ActionNode) string {
+	pos := int(n.Position())
+	left, right := g.t.delims.left, g.t.delims.right
+	start := strings.LastIndex(g.text[:pos], left)
+	end := strings.Index(g.text[pos:], right)
+	if start < 0 || end < 0 {
+		return n.String()
+	}
+	return g.text[start : pos+end+len(right)]
+}
+
+func (g *fieldGuard) pipe(p *parse.PipeNode, action string) {
+	if p == nil {
+		return
+	}
+	for _, cmd := range p.Cmds {
+		for i, arg := range cmd.Args {
+			// A field followed by arguments is a method call
+			if i == 0 && len(cmd.Args) > 1 {
+				if _, ok := arg.(*parse.IdentifierNode); ok {
+					continue
+				}
+				if _, ok := arg.(*parse.FieldNode); ok {
+					continue
+				}
+				if v, ok := arg.(*parse.VariableNode); ok && len(v.Ident) > 1 {
+					continue
+				}
+			}
+			if sub, ok := arg.(*parse.PipeNode); ok {
+				g.pipe(sub, "")
+				continue
+			}
+			if call := g.rewrite(arg, action); call != nil {
+				cmd.Args[i] = call
+			}
+		}
+	}
+}
+
+// rewrite returns the fieldFunc call replacing arg, or nil if arg is not a
+// field reference
+func (g *fieldGuard) rewrite(arg parse.Node, action string) parse.Node {
+	pos := arg.Position()
+	root := &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: []string{"$"}}
+	switch n := arg.(type) {
+	case *parse.FieldNode:
+		return g.call(&parse.DotNode{NodeType: parse.NodeDot, Pos: pos}, n.Ident, action)
+	case *parse.VariableNode:
+		if len(n.Ident) < 2 {
+			return nil
+		}
+		base := &parse.VariableNode{NodeType: parse.NodeVariable, Pos: pos, Ident: n.Ident[:1]}
+		return g.call(base, n.Ident[1:], action)
+	case *parse.ChainNode:
+		id, ok := n.Node.(*parse.IdentifierNode)
+		if !ok || g.isFunc(id.Ident) {
+			return nil
+		}
+		// Names bound by {{set}} are template functions returning their value
+		if _, ok := g.t.lookupVar(id.Ident); ok {
+			return g.call(id, n.Field, action)
+		}
+		return g.call(root, append([]string{id.Ident}, n.Field...), action)
+	case *parse.IdentifierNode:
+		if _, ok := g.keys[n.Ident]; ok || g.isFunc(n.Ident) {
+			return nil
+		}
+		if _, ok := g.t.lookupVar(n.Ident); ok {
+			return nil
+		}
+		return g.call(root, []string{n.Ident}, action)
+	}
+	return nil
+}
+
+func (g *fieldGuard) call(base parse.Node, path []string, action string) parse.Node {
+	pos := base.Position()
+	str := func(s string) *parse.StringNode {
+		return &parse.StringNode{NodeType: parse.NodeString, Pos: pos, Quoted: strconv.Quote(s), Text: s}
+	}
+	cmd := &parse.CommandNode{NodeType: parse.NodeCommand, Pos: pos, Args: []parse.Node{
+		&parse.IdentifierNode{NodeType: parse.NodeIdentifier, Pos: pos, Ident: fieldFunc},
+		base,
+		str(strings.Join(path, ".")),
+		str(action),
+	}}
+	return &parse.PipeNode{NodeType: parse.NodePipe, Pos: pos, Cmds: []*parse.CommandNode{cmd}}
+}
+
+// isFunc reports whether name is a template function other than a data key
+func (g *fieldGuard) isFunc(name string) bool {
+	if _, ok := g.keys[name]; ok {
+		return false
+	}
+	if _, ok := g.t.funcs[name]; ok {
+		return true
+	}
+	for _, f := range builtinFuncs {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
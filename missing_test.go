@@ -0,0 +1,51 @@
+package docxexp
+
+import (
+	"errors"
+	"slices"
+	"testing"
+)
+
+func TestMissingKey(t *testing.T) {
+	data := map[string]interface{}{"Name": "Ann"}
+	onMissing := func(expr string, _ Location) (interface{}, error) {
+		return "?" + expr, nil
+	}
+	tests := []struct {
+		name   string
+		policy MissingKey
+		lines  []string
+		want   []string
+		err    bool
+	}{
+		{"default inline", MissingKeyDefault, []string{"a {{ .Nope }} b"}, []string{"a <no value> b"}, false},
+		{"default block", MissingKeyDefault, []string{"{{if Nope}}", "x", "{{endif}}"}, nil, true},
+		{"error inline", MissingKeyError, []string{"a {{ .Nope }} b"}, nil, true},
+		{"empty inline", MissingKeyEmpty, []string{"a {{ .Nope }} b", "{{ .Name }}"}, []string{"a  b", "Ann"}, false},
+		{"empty if", MissingKeyEmpty, []string{"{{if Nope}}", "x", "{{endif}}", "y"}, []string{"y"}, false},
+		{"empty for", MissingKeyEmpty, []string{"{{for v in Nope}}", "{{ v }}", "{{endfor}}", "y"}, []string{"y"}, false},
+		{"verbatim inline", MissingKeyVerbatim, []string{"a {{ .Nope }} {{ .Name }}"}, []string{"a {{ .Nope }} Ann"}, false},
+		{"verbatim block", MissingKeyVerbatim, []string{"{{if Nope}}", "{{ .Name }}", "{{endif}}"}, []string{"{{if Nope}}", "{{ .Name }}", "{{endif}}"}, false},
+		{"func inline", MissingKeyFunc, []string{"a {{ .Nope }}"}, []string{"a ?Nope"}, false},
+		{"func block", MissingKeyFunc, []string{"{{if Nope}}", "x", "{{endif}}"}, []string{"x"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			err := tpl.RenderWithOptions(data, RenderOptions{MissingKey: tt.policy, OnMissingKey: onMissing})
+			if tt.err {
+				var fe *FieldError
+				if !errors.As(err, &fe) {
+					t.Fatalf("err = %v, want a FieldError", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,426 @@
+package docxexp
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+const (
+	stylesPart    = "word/styles.xml"
+	numberingPart = "word/numbering.xml"
+	relsPart      = "word/_rels/document.xml.rels"
+	typesPart     = "[Content_Types].xml"
+)
+
+// rewritePackage copies the docx package in data to w, passing the parts
+// listed in patches through their patch function. Patches for parts missing
+// from the package are called with nil and add the part unless they return nil.
+func rewritePackage(data []byte, w io.Writer, patches map[string]func([]byte) ([]byte, error)) error {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	seen := make(map[string]bool)
+	for _, f := range zr.File {
+		seen[f.Name] = true
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		part, err := io.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+
+		if patch, ok := patches[f.Name]; ok {
+			part, err = patch(part)
+			if err != nil {
+				return err
+			}
+		}
+
+		fw, err := zw.Create(f.Name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(part); err != nil {
+			return err
+		}
+	}
+
+	var added []string
+	for name := range patches {
+		if !seen[name] {
+			added = append(added, name)
+		}
+	}
+	sort.Strings(added)
+	for _, name := range added {
+		part, err := patches[name](nil)
+		if err != nil {
+			return err
+		}
+		if part == nil {
+			continue
+		}
+		fw, err := zw.Create(name)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(part); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// readPart returns the part name of the package zr, or nil if it has none
+func readPart(zr *zip.Reader, name string) ([]byte, error) {
+	for _, f := range zr.File {
+		if f.Name != name {
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer rc.Close()
+		return io.ReadAll(rc)
+	}
+	return nil, nil
+}
+
+// merged holds what was copied into the package of the template from other
+// packages by {{include}} and Merge
+type merged struct {
+	styles       []string
+	abstractNums []string
+	nums         []string
+	// xmlns are the namespaces declared by the parts the definitions were
+	// copied from, by prefix
+	xmlns map[string]string
+
+	// parts are the parts copied into the package, by name
+	parts map[string][]byte
+	// overrides and defaults are the content types of the copied parts, by
+	// part name and by extension
+	overrides, defaults map[string]string
+	// rels are the document relationships to the copied parts, written as
+	// relPlaceholder(i) until the package is saved
+	rels []relationship
+	// sections are the properties of the sections ended by a sectionMarker
+	// and last those of the final section, as raw XML
+	sections []string
+	last     string
+	// seq counts the documents merged, whose copied parts are prefixed
+	// with their number
+	seq int
+}
+
+// relationship is a document relationship to a part of the package
+type relationship struct {
+	typ, target string
+}
+
+var (
+	relIDRe          = regexp.MustCompile(`Id="rId(\d+)"`)
+	xmlnsRe          = regexp.MustCompile(`xmlns:(\w+)="([^"]*)"`)
+	relPlaceholderRe = regexp.MustCompile(`docxexp-rel-(\d+)`)
+	sectionMarkerRe  = regexp.MustCompile(`<!-- docxexp:sect:(\d+) -->`)
+)
+
+func relPlaceholder(i int) string {
+	return fmt.Sprintf("docxexp-rel-%d", i)
+}
+
+// clone returns a copy of m that can be extended independently
+func (m *merged) clone() merged {
+	c := merged{
+		styles:       append([]string(nil), m.styles...),
+		abstractNums: append([]string(nil), m.abstractNums...),
+		nums:         append([]string(nil), m.nums...),
+		rels:         append([]relationship(nil), m.rels...),
+		sections:     append([]string(nil), m.sections...),
+		last:         m.last,
+		seq:          m.seq,
+	}
+	c.xmlns = cloneMap(m.xmlns)
+	c.overrides = cloneMap(m.overrides)
+	c.defaults = cloneMap(m.defaults)
+	if m.parts != nil {
+		c.parts = make(map[string][]byte, len(m.parts))
+		for k, v := range m.parts {
+			c.parts[k] = v
+		}
+	}
+	return c
+}
+
+func cloneMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]string, len(m))
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// addPart copies data into the package as name with the content type ct,
+// given by extension when byExt is set
+func (m *merged) addPart(name string, data []byte, ct string, byExt bool) {
+	if m.parts == nil {
+		m.parts = make(map[string][]byte)
+		m.overrides = make(map[string]string)
+		m.defaults = make(map[string]string)
+	}
+	m.parts[name] = data
+	switch {
+	case ct == "":
+	case byExt:
+		m.defaults[strings.TrimPrefix(path.Ext(name), ".")] = ct
+	default:
+		m.overrides[name] = ct
+	}
+}
+
+// packagePart returns the part name of the template package with what was
+// merged into it, or nil if there is no such part
+func (t *DocxTemplate) packagePart(name string) ([]byte, error) {
+	data, ok := t.merged.parts[name]
+	if !ok {
+		var err error
+		if data, err = readPart(t.pkg, name); err != nil {
+			return nil, err
+		}
+	}
+	if patch, ok := t.merged.patches(t.pkg, 0)[name]; ok {
+		return patch(data)
+	}
+	return data, nil
+}
+
+// patches returns the patches adding what was merged to the parts of pkg,
+// creating the styles and numbering parts it lacks. Relationships added to
+// the document are numbered from rIdnext.
+func (m *merged) patches(pkg *zip.Reader, next int) map[string]func([]byte) ([]byte, error) {
+	patches := make(map[string]func([]byte) ([]byte, error))
+	rels := append([]relationship(nil), m.rels...)
+	overrides := cloneMap(m.overrides)
+	if overrides == nil {
+		overrides = make(map[string]string)
+	}
+	create := func(name string) {
+		if hasPart(pkg, name) {
+			return
+		}
+		kind := strings.TrimSuffix(strings.TrimPrefix(name, "word/"), ".xml")
+		rels = append(rels, relationship{
+			typ:    "http://schemas.openxmlformats.org/officeDocument/2006/relationships/" + kind,
+			target: kind + ".xml",
+		})
+		overrides[name] = "application/vnd.openxmlformats-officedocument.wordprocessingml." + kind + "+xml"
+	}
+
+	if len(m.styles) > 0 {
+		patches[stylesPart] = func(data []byte) ([]byte, error) {
+			if data == nil {
+				data = []byte(xmlPart("w:styles"))
+			}
+			data = m.namespaces(data, "w:styles")
+			return insertBefore(data, strings.Join(m.styles, ""), "</w:styles>"), nil
+		}
+		create(stylesPart)
+	}
+	if len(m.nums) > 0 || len(m.abstractNums) > 0 {
+		patches[numberingPart] = func(data []byte) ([]byte, error) {
+			if data == nil {
+				data = []byte(xmlPart("w:numbering"))
+			}
+			data = m.namespaces(data, "w:numbering")
+			data = insertBefore(data, strings.Join(m.abstractNums, ""), "<w:num ", "<w:numIdMacAtCleanup", "</w:numbering>")
+			return insertBefore(data, strings.Join(m.nums, ""), "<w:numIdMacAtCleanup", "</w:numbering>"), nil
+		}
+		create(numberingPart)
+	}
+	for name, part := range m.parts {
+		part := part
+		patches[name] = func([]byte) ([]byte, error) { return part, nil }
+	}
+
+	if len(rels) > 0 {
+		patches[relsPart] = func(data []byte) ([]byte, error) {
+			var sb strings.Builder
+			for i, rel := range rels {
+				fmt.Fprintf(&sb, `<Relationship Id="rId%d" Type="%s" Target="%s"/>`, next+i, rel.typ, rel.target)
+			}
+			return insertBefore(data, sb.String(), "</Relationships>"), nil
+		}
+	}
+	if len(overrides) > 0 || len(m.defaults) > 0 {
+		patches[typesPart] = func(data []byte) ([]byte, error) {
+			var sb strings.Builder
+			for _, ext := range sortedKeys(m.defaults) {
+				if !bytes.Contains(data, []byte(`Extension="`+ext+`"`)) {
+					fmt.Fprintf(&sb, `<Default Extension="%s" ContentType="%s"></Default>`, ext, m.defaults[ext])
+				}
+			}
+			for _, name := range sortedKeys(overrides) {
+				if !bytes.Contains(data, []byte(`PartName="/`+name+`"`)) {
+					fmt.Fprintf(&sb, `<Override PartName="/%s" ContentType="%s"></Override>`, name, overrides[name])
+				}
+			}
+			return insertBefore(data, sb.String(), "</Types>"), nil
+		}
+	}
+	if len(m.sections) > 0 || m.last != "" {
+		patches[documentPart] = func(data []byte) ([]byte, error) {
+			return m.applySections(data, next), nil
+		}
+	}
+	return patches
+}
+
+// applySections gives the paragraphs holding a sectionMarker and the body of
+// document.xml their section properties
+func (m *merged) applySections(data []byte, next int) []byte {
+	data = sectionMarkerRe.ReplaceAllFunc(data, func(marker []byte) []byte {
+		i, _ := strconv.Atoi(string(sectionMarkerRe.FindSubmatch(marker)[1]))
+		if i >= len(m.sections) {
+			return nil
+		}
+		return []byte("<w:pPr>" + m.sections[i] + "</w:pPr>")
+	})
+	if m.last != "" {
+		body := bytes.LastIndex(data, []byte("</w:body>"))
+		start := bytes.LastIndex(data[:max(body, 0)], []byte("<w:sectPr"))
+		if body >= 0 {
+			end := body
+			if start >= 0 {
+				if e := bytes.Index(data[start:body], []byte("</w:sectPr>")); e >= 0 {
+					end = start + e + len("</w:sectPr>")
+				}
+			} else {
+				start = body
+			}
+			out := make([]byte, 0, len(data)+len(m.last))
+			out = append(out, data[:start]...)
+			out = append(out, m.last...)
+			data = append(out, data[end:]...)
+		}
+	}
+	return relPlaceholderRe.ReplaceAllFunc(data, func(ph []byte) []byte {
+		i, _ := strconv.Atoi(string(relPlaceholderRe.FindSubmatch(ph)[1]))
+		return []byte(fmt.Sprintf("rId%d", next+i))
+	})
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// declare records the namespaces declared by the root element of data
+func (m *merged) declare(data []byte, root string) {
+	if m.xmlns == nil {
+		m.xmlns = make(map[string]string)
+	}
+	if end := rootTag(data, root); end > 0 {
+		for _, ns := range xmlnsRe.FindAllSubmatch(data[:end], -1) {
+			m.xmlns[string(ns[1])] = string(ns[2])
+		}
+	}
+}
+
+// namespaces declares on the root element of data the recorded namespaces
+// it lacks, which the copied definitions may use
+func (m *merged) namespaces(data []byte, root string) []byte {
+	end := rootTag(data, root)
+	if end < 0 {
+		return data
+	}
+	declared := make(map[string]bool)
+	for _, ns := range xmlnsRe.FindAllSubmatch(data[:end], -1) {
+		declared[string(ns[1])] = true
+	}
+	prefixes := make([]string, 0, len(m.xmlns))
+	for prefix := range m.xmlns {
+		if !declared[prefix] {
+			prefixes = append(prefixes, prefix)
+		}
+	}
+	sort.Strings(prefixes)
+	var sb strings.Builder
+	for _, prefix := range prefixes {
+		fmt.Fprintf(&sb, ` xmlns:%s="%s"`, prefix, m.xmlns[prefix])
+	}
+	out := make([]byte, 0, len(data)+sb.Len())
+	out = append(out, data[:end]...)
+	out = append(out, sb.String()...)
+	return append(out, data[end:]...)
+}
+
+// rootTag returns the offset of the closing bracket of the start tag of the
+// root element of data, or -1 if there is none
+func rootTag(data []byte, root string) int {
+	start := bytes.Index(data, []byte("<"+root))
+	if start < 0 {
+		return -1
+	}
+	end := bytes.IndexByte(data[start:], '>')
+	if end < 0 {
+		return -1
+	}
+	if data[start+end-1] == '/' {
+		end--
+	}
+	return start + end
+}
+
+// xmlPart returns an empty WordprocessingML part with the root element root
+func xmlPart(root string) string {
+	return `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>` + "\n" +
+		`<` + root + ` xmlns:w="` + docx.XMLNS_W + `"></` + root + `>`
+}
+
+// insertBefore inserts s into data before the first of marks found in it
+func insertBefore(data []byte, s string, marks ...string) []byte {
+	if s == "" {
+		return data
+	}
+	for _, mark := range marks {
+		if i := bytes.Index(data, []byte(mark)); i >= 0 {
+			out := make([]byte, 0, len(data)+len(s))
+			out = append(out, data[:i]...)
+			out = append(out, s...)
+			return append(out, data[i:]...)
+		}
+	}
+	return data
+}
+
+func hasPart(pkg *zip.Reader, name string) bool {
+	for _, f := range pkg.File {
+		if f.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,224 @@
+package docxexp
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// Run markers are placed in the template text of a paragraph at the start of
+// each run, outside of any tag, so that the rendered text can be split back
+// into the runs it came from. Text between inline {{if}} or {{range}} and
+// {{end}} keeps the formatting of its own runs this way.
+const (
+	runMarkOpen  = "\uE000"
+	runMarkClose = "\uE001"
+)
+
+var runMarkRe = regexp.MustCompile(`\x{E000}(\d+)\x{E001}`)
+
+// markRuns returns the text of p with run markers. It reports false when p
+// has fewer than two runs and needs no markers.
+func (t *DocxTemplate) markRuns(p *docx.Paragraph) (string, bool) {
+	var texts []string
+	for _, child := range p.Children {
+		if run, ok := child.(*docx.Run); ok {
+			var sb strings.Builder
+			for _, rc := range run.Children {
+				if text, ok := rc.(*docx.Text); ok {
+					sb.WriteString(text.Text)
+				}
+			}
+			texts = append(texts, sb.String())
+		}
+	}
+	if len(texts) < 2 {
+		return "", false
+	}
+
+	full := strings.Join(texts, "")
+	spans := t.delims.tagSpans(full)
+	var sb strings.Builder
+	offset := 0
+	for i, text := range texts {
+		// Tags closing a block belong to the runs before them
+		skip := t.delims.closingTags(text)
+		sb.WriteString(text[:skip])
+		if t.delims.canMark(full, offset+skip, spans) {
+			fmt.Fprintf(&sb, "%s%d%s", runMarkOpen, i, runMarkClose)
+		}
+		sb.WriteString(text[skip:])
+		offset += len(text)
+	}
+	return sb.String(), true
+}
+
+// closingTags returns the length of the {{end}} and {{else}} tags that text
+// starts with
+func (d *delims) closingTags(text string) int {
+	n := 0
+	for strings.HasPrefix(text[n:], d.left) {
+		end := strings.Index(text[n+len(d.left):], d.right)
+		if end < 0 {
+			break
+		}
+		content := strings.Trim(text[n+len(d.left):n+len(d.left)+end], " -")
+		if content != "end" && content != "else" && !strings.HasPrefix(content, "else ") {
+			break
+		}
+		n += len(d.left) + end + len(d.right)
+	}
+	return n
+}
+
+// tagSpans returns the start and end offsets of the tags in text
+func (d *delims) tagSpans(text string) [][2]int {
+	var spans [][2]int
+	for i := 0; i < len(text); {
+		start := strings.Index(text[i:], d.left)
+		if start < 0 {
+			break
+		}
+		start += i
+		if start > 0 && text[start-1] == '\\' {
+			i = start + len(d.left)
+			continue
+		}
+		end := strings.Index(text[start+len(d.left):], d.right)
+		if end < 0 {
+			spans = append(spans, [2]int{start, len(text)})
+			break
+		}
+		end += start + len(d.left) + len(d.right)
+		spans = append(spans, [2]int{start, end})
+		i = end
+	}
+	return spans
+}
+
+// canMark reports whether a run marker can be placed at offset of text
+// without splitting a tag, an escaped delimiter or the whitespace removed by
+// a {{- -}} trim marker
+func (d *delims) canMark(text string, offset int, spans [][2]int) bool {
+	for _, s := range spans {
+		if offset > s[0] && offset < s[1] {
+			return false
+		}
+	}
+	if offset > 0 && text[offset-1] == '\\' &&
+		(strings.HasPrefix(text[offset:], d.left) || strings.HasPrefix(text[offset:], d.right)) {
+		return false
+	}
+	if strings.HasPrefix(strings.TrimLeft(text[offset:], " \t"), d.left+"-") ||
+		strings.HasSuffix(strings.TrimRight(text[:offset], " \t"), "-"+d.right) {
+		return false
+	}
+	return true
+}
+
+// splitRuns rebuilds the runs of p from rendered text holding the run
+// markers of source, the marked template text. Runs are repeated or dropped
+// as their markers are, and children added to p after the first n, such as
+// injected drawings, are kept at the end.
+func splitRuns(p *docx.Paragraph, n int, source, rendered string) {
+	original, extra := p.Children[:n], p.Children[n:]
+
+	// runs are the indexes in original of the runs
+	var runs []int
+	for i, child := range original {
+		if _, ok := child.(*docx.Run); ok {
+			runs = append(runs, i)
+		}
+	}
+	marked := make(map[int]bool)
+	for _, m := range runMarkRe.FindAllStringSubmatch(source, -1) {
+		k, _ := strconv.Atoi(m[1])
+		marked[k] = true
+	}
+
+	type segment struct {
+		run  int
+		text string
+	}
+	var segments []segment
+	locs := runMarkRe.FindAllStringSubmatchIndex(rendered, -1)
+	if len(locs) == 0 || locs[0][0] > 0 {
+		end := len(rendered)
+		if len(locs) > 0 {
+			end = locs[0][0]
+		}
+		segments = append(segments, segment{run: 0, text: rendered[:end]})
+	}
+	for i, loc := range locs {
+		k, _ := strconv.Atoi(rendered[loc[2]:loc[3]])
+		end := len(rendered)
+		if i+1 < len(locs) {
+			end = locs[i+1][0]
+		}
+		segments = append(segments, segment{run: k, text: rendered[loc[1]:end]})
+	}
+
+	var children []interface{}
+	next := 0
+	// flush keeps the children other than runs found before index
+	flush := func(index int) {
+		for ; next < index; next++ {
+			if _, ok := original[next].(*docx.Run); !ok {
+				children = append(children, original[next])
+			}
+		}
+	}
+	for _, seg := range segments {
+		if seg.run >= len(runs) {
+			continue
+		}
+		flush(runs[seg.run])
+		// Runs starting inside a tag are rendered with the run holding it
+		group := []int{seg.run}
+		for k := seg.run + 1; k < len(runs) && !marked[k]; k++ {
+			group = append(group, k)
+		}
+		if run := buildRun(original, runs, group, seg.text); run != nil {
+			children = append(children, run)
+		}
+	}
+	flush(len(original))
+	p.Children = append(children, extra...)
+}
+
+// buildRun returns a copy of the first run of group holding text and the
+// children other than text of every run in group, or nil if it would be empty
+func buildRun(original []interface{}, runs []int, group []int, text string) *docx.Run {
+	first := original[runs[group[0]]].(*docx.Run)
+	run := *first
+	run.Children = nil
+	placed := false
+	for _, k := range group {
+		for _, rc := range original[runs[k]].(*docx.Run).Children {
+			t, ok := rc.(*docx.Text)
+			if !ok {
+				run.Children = append(run.Children, rc)
+				continue
+			}
+			if !placed {
+				nt := *t
+				nt.Text = text
+				if text != strings.TrimSpace(text) {
+					nt.XMLSpace = "preserve"
+				}
+				run.Children = append(run.Children, &nt)
+				placed = true
+			}
+		}
+	}
+	if !placed && text != "" {
+		run.Children = append(run.Children, &docx.Text{Text: text, XMLSpace: "preserve"})
+	}
+	if len(run.Children) == 0 || (len(run.Children) == 1 && placed && text == "") {
+		return nil
+	}
+	return &run
+}
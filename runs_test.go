@@ -0,0 +1,83 @@
+package docxexp
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// runTemplate returns a template with one paragraph made of runs, those
+// written as *text* being bold
+func runTemplate(t *testing.T, runs ...string) *DocxTemplate {
+	t.Helper()
+	return newTestTemplate(t, func(doc *docx.Docx) {
+		p := doc.AddParagraph()
+		for _, r := range runs {
+			if bold := strings.TrimSuffix(strings.TrimPrefix(r, "*"), "*"); bold != r {
+				p.AddText(bold).Bold()
+			} else {
+				p.AddText(r)
+			}
+		}
+	})
+}
+
+// runTexts returns the text of each run of the first paragraph of tpl,
+// written as *text* when the run is bold
+func runTexts(tpl *DocxTemplate) []string {
+	var out []string
+	p := tpl.doc.Document.Body.Items[0].(*docx.Paragraph)
+	for _, child := range p.Children {
+		r, ok := child.(*docx.Run)
+		if !ok {
+			continue
+		}
+		var sb strings.Builder
+		for _, rc := range r.Children {
+			if text, ok := rc.(*docx.Text); ok {
+				sb.WriteString(text.Text)
+			}
+		}
+		if r.RunProperties != nil && r.RunProperties.Bold != nil {
+			out = append(out, "*"+sb.String()+"*")
+		} else {
+			out = append(out, sb.String())
+		}
+	}
+	return out
+}
+
+func TestSplitRuns(t *testing.T) {
+	data := map[string]interface{}{
+		"Name":  "Ann",
+		"Hosts": []string{"a", "b"},
+		"Show":  true,
+		"Hide":  false,
+	}
+	tests := []struct {
+		name string
+		runs []string
+		want []string
+	}{
+		{"single run", []string{"Hello {{ .Name }}"}, []string{"Hello Ann"}},
+		{"formatting kept", []string{"Hello ", "*{{ .Name }}*", "!"}, []string{"Hello ", "*Ann*", "!"}},
+		{"tag across runs", []string{"Hello {{ .Na", "me }}!"}, []string{"Hello Ann!"}},
+		{"range", []string{"Affects {{range .Hosts}}", "*{{.}}*", ", {{end}}"}, []string{"Affects ", "*a*", ", ", "*b*", ", "}},
+		{"if kept", []string{"{{if .Show}}", "*shown*", "{{end}} after"}, []string{"*shown*", " after"}},
+		{"if dropped", []string{"before {{if .Hide}}", "*hidden*", "{{end}} after"}, []string{"before ", " after"}},
+		{"else", []string{"{{if .Hide}}", "*yes*", "{{else}}", "no", "{{end}}"}, []string{"no"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := runTemplate(t, tt.runs...)
+			if err := tpl.Render(data); err != nil {
+				t.Fatal(err)
+			}
+			if got := runTexts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("runs = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
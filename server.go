@@ -0,0 +1,284 @@
+package docxexp
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ServerOptions controls the handler returned by NewServer
+type ServerOptions struct {
+	// MaxTemplateSize caps uploaded templates in bytes, 32 MiB when 0
+	MaxTemplateSize int64
+	// MaxDataSize caps the JSON data of a render request in bytes, 4 MiB
+	// when 0
+	MaxDataSize int64
+	// RenderTimeout caps each render, 30 seconds when 0
+	RenderTimeout time.Duration
+	// Render are the options templates are rendered with. {{include}} opens
+	// templates from Render.IncludeFS only and fails when it is nil, so
+	// requests cannot read the files of the server.
+	Render RenderOptions
+	// ReadOnly rejects uploads and deletions, serving registered templates only
+	ReadOnly bool
+	// Decoder decodes the data of render requests, which is plain JSON when
+	// it is nil. Injectors decoded from requests read the files and URLs they
+	// name, so only register the tags clients may use.
+	Decoder *Decoder
+}
+
+// Server renders templates over HTTP. Templates are registered with Register
+// or uploaded, parsed once and cloned for each render. Its routes are:
+//
+//	GET    /healthz                  reports that the server is up
+//	GET    /templates                lists the template names
+//	PUT    /templates/{name}         uploads a template, the body being the .docx
+//	GET    /templates/{name}         returns the Inspection of a template
+//	DELETE /templates/{name}         removes a template
+//	POST   /templates/{name}/render  renders a template with the JSON body as data
+//
+// Errors are returned as {"error": "..."} objects.
+type Server struct {
+	opts ServerOptions
+	mux  *http.ServeMux
+
+	mu        sync.RWMutex
+	templates map[string]*DocxTemplate
+}
+
+const docxContentType = "application/vnd.openxmlformats-officedocument.wordprocessingml.document"
+
+var templateNameRe = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// NewServer returns a server with no templates
+func NewServer(opts ServerOptions) *Server {
+	if opts.MaxTemplateSize == 0 {
+		opts.MaxTemplateSize = 32 << 20
+	}
+	if opts.MaxDataSize == 0 {
+		opts.MaxDataSize = 4 << 20
+	}
+	if opts.RenderTimeout == 0 {
+		opts.RenderTimeout = 30 * time.Second
+	}
+	if opts.Render.IncludeFS == nil {
+		opts.Render.IncludeFS = emptyFS{}
+	}
+	s := &Server{opts: opts, mux: http.NewServeMux(), templates: make(map[string]*DocxTemplate)}
+	s.mux.HandleFunc("GET /healthz", s.health)
+	s.mux.HandleFunc("GET /templates", s.list)
+	s.mux.HandleFunc("PUT /templates/{name}", s.upload)
+	s.mux.HandleFunc("GET /templates/{name}", s.inspect)
+	s.mux.HandleFunc("DELETE /templates/{name}", s.remove)
+	s.mux.HandleFunc("POST /templates/{name}/render", s.render)
+	return s
+}
+
+// Register makes t available under name, replacing the template registered
+// under that name if any. Names are made of letters, digits, '.', '_' and '-'.
+// t is not rendered by the server, which renders clones of it.
+func (s *Server) Register(name string, t *DocxTemplate) error {
+	if !templateNameRe.MatchString(name) {
+		return fmt.Errorf("invalid template name %q", name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.templates[name] = t
+	return nil
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mux.ServeHTTP(w, r)
+}
+
+func (s *Server) template(name string) *DocxTemplate {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.templates[name]
+}
+
+func (s *Server) health(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+func (s *Server) list(w http.ResponseWriter, _ *http.Request) {
+	s.mu.RLock()
+	names := make([]string, 0, len(s.templates))
+	for name := range s.templates {
+		names = append(names, name)
+	}
+	s.mu.RUnlock()
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, map[string][]string{"templates": names})
+}
+
+func (s *Server) upload(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("templates are read-only"))
+		return
+	}
+	b, err := io.ReadAll(http.MaxBytesReader(w, r.Body, s.opts.MaxTemplateSize))
+	if err != nil {
+		writeError(w, bodyStatus(err), err)
+		return
+	}
+	t, err := New(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("template: %w", err))
+		return
+	}
+	name := r.PathValue("name")
+	if err := s.Register(name, t); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	diags := t.Lint()
+	if diags == nil {
+		diags = []Diagnostic{}
+	}
+	writeJSON(w, http.StatusCreated, map[string]interface{}{"name": name, "diagnostics": diags})
+}
+
+func (s *Server) inspect(w http.ResponseWriter, r *http.Request) {
+	t := s.template(r.PathValue("name"))
+	if t == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("template %q not found", r.PathValue("name")))
+		return
+	}
+	c, err := t.Clone()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, c.Inspect())
+}
+
+func (s *Server) remove(w http.ResponseWriter, r *http.Request) {
+	if s.opts.ReadOnly {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("templates are read-only"))
+		return
+	}
+	name := r.PathValue("name")
+	s.mu.Lock()
+	_, ok := s.templates[name]
+	delete(s.templates, name)
+	s.mu.Unlock()
+	if !ok {
+		writeError(w, http.StatusNotFound, fmt.Errorf("template %q not found", name))
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) render(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	t := s.template(name)
+	if t == nil {
+		writeError(w, http.StatusNotFound, fmt.Errorf("template %q not found", name))
+		return
+	}
+	var data interface{}
+	body := http.MaxBytesReader(w, r.Body, s.opts.MaxDataSize)
+	var err error
+	if s.opts.Decoder != nil {
+		data, err = s.opts.Decoder.DecodeJSON(body)
+	} else {
+		err = json.NewDecoder(body).Decode(&data)
+	}
+	if err != nil {
+		writeError(w, bodyStatus(err), fmt.Errorf("data: %w", err))
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), s.opts.RenderTimeout)
+	defer cancel()
+	type result struct {
+		out []byte
+		err error
+	}
+	// Render stops between items once ctx is done, but an injector that does
+	// not take ctx may run on; its result is then dropped
+	done := make(chan result, 1)
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				done <- result{err: fmt.Errorf("render: panic: %v", v)}
+			}
+		}()
+		doc, err := t.renderRecord(ctx, data, s.opts.Render)
+		var buf bytes.Buffer
+		if err == nil {
+			err = doc.Save(&buf)
+		}
+		done <- result{buf.Bytes(), err}
+	}()
+	var res result
+	select {
+	case <-ctx.Done():
+		writeError(w, http.StatusGatewayTimeout, fmt.Errorf("render: %w", ctx.Err()))
+		return
+	case res = <-done:
+	}
+	if errors.Is(res.err, context.DeadlineExceeded) {
+		writeError(w, http.StatusGatewayTimeout, fmt.Errorf("render: %w", res.err))
+		return
+	}
+	if res.err != nil {
+		writeError(w, http.StatusUnprocessableEntity, res.err)
+		return
+	}
+	w.Header().Set("Content-Type", docxContentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.docx"`, name))
+	w.Header().Set("Content-Length", fmt.Sprint(len(res.out)))
+	w.WriteHeader(http.StatusOK)
+	w.Write(res.out)
+}
+
+// bodyStatus returns the status reporting err, returned reading a request body
+func bodyStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse is the body of an error response
+type errorResponse struct {
+	Error string `json:"error"`
+	// Location is where in the template a render error happened
+	Location *Location `json:"location,omitempty"`
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	res := errorResponse{Error: err.Error()}
+	var re *RenderError
+	if errors.As(err, &re) {
+		loc := re.Location
+		res.Location = &loc
+	}
+	writeJSON(w, status, res)
+}
+
+// emptyFS is a file system without files
+type emptyFS struct{}
+
+func (emptyFS) Open(name string) (fs.File, error) {
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
@@ -0,0 +1,132 @@
+package docxexp
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fumiama/go-docx"
+)
+
+// request sends a request to srv and returns the status and body of the response
+func request(t *testing.T, srv *httptest.Server, method, path string, body []byte) (int, []byte, http.Header) {
+	t.Helper()
+	req, err := http.NewRequest(method, srv.URL+path, bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := srv.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, data, res.Header
+}
+
+func TestServer(t *testing.T) {
+	hello := packageOf(t, func(doc *docx.Docx) { doc.AddParagraph().AddText("Hello {{ .Name }}") })
+	broken := packageOf(t, func(doc *docx.Docx) { doc.AddParagraph().AddText("{{for v in Items}}") })
+	include := packageOf(t, func(doc *docx.Docx) { doc.AddParagraph().AddText(`{{include "/etc/hostname"}}`) })
+	srv := httptest.NewServer(NewServer(ServerOptions{MaxTemplateSize: 64 << 10, MaxDataSize: 64}))
+	defer srv.Close()
+
+	// The steps run in order against the same server
+	steps := []struct {
+		name         string
+		method, path string
+		body         []byte
+		status       int
+		// want is part of the JSON response expected, or the text of the
+		// rendered document
+		want string
+	}{
+		{"health", "GET", "/healthz", nil, http.StatusOK, `"ok"`},
+		{"empty list", "GET", "/templates", nil, http.StatusOK, `{"templates":[]}`},
+		{"upload", "PUT", "/templates/hello", hello, http.StatusCreated, `"name":"hello"`},
+		{"upload broken", "PUT", "/templates/broken", broken, http.StatusCreated, `block end {{endfor}} not found`},
+		{"upload include", "PUT", "/templates/include", include, http.StatusCreated, `"name":"include"`},
+		{"not a docx", "PUT", "/templates/bad", []byte("nope"), http.StatusBadRequest, `"error":"template:`},
+		{"bad name", "PUT", "/templates/a%20b", hello, http.StatusBadRequest, `invalid template name`},
+		{"too large", "PUT", "/templates/big", make([]byte, 65<<10), http.StatusRequestEntityTooLarge, `"error"`},
+		{"list", "GET", "/templates", nil, http.StatusOK, `{"templates":["broken","hello","include"]}`},
+		{"inspect", "GET", "/templates/hello", nil, http.StatusOK, `"Name"`},
+		{"render", "POST", "/templates/hello/render", []byte(`{"Name":"Ann"}`), http.StatusOK, "Hello Ann"},
+		{"render again", "POST", "/templates/hello/render", []byte(`{"Name":"Bob"}`), http.StatusOK, "Hello Bob"},
+		{"bad data", "POST", "/templates/hello/render", []byte(`{`), http.StatusBadRequest, `"error":"data:`},
+		{"data too large", "POST", "/templates/hello/render", []byte(`{"Name":"` + strings.Repeat("a", 100) + `"}`), http.StatusRequestEntityTooLarge, `"error":"data:`},
+		{"render error", "POST", "/templates/broken/render", []byte(`{}`), http.StatusUnprocessableEntity, `"location":{`},
+		{"include closed", "POST", "/templates/include/render", []byte(`{}`), http.StatusUnprocessableEntity, `"error"`},
+		{"unknown", "POST", "/templates/none/render", []byte(`{}`), http.StatusNotFound, `not found`},
+		{"delete", "DELETE", "/templates/hello", nil, http.StatusNoContent, ""},
+		{"deleted", "GET", "/templates/hello", nil, http.StatusNotFound, `not found`},
+		{"delete again", "DELETE", "/templates/hello", nil, http.StatusNotFound, `not found`},
+	}
+	for _, st := range steps {
+		status, body, header := request(t, srv, st.method, st.path, st.body)
+		if status != st.status {
+			t.Fatalf("%s: status %d, want %d: %s", st.name, status, st.status, body)
+		}
+		if header.Get("Content-Type") == docxContentType {
+			doc, err := New(bytes.NewReader(body), int64(len(body)))
+			if err != nil {
+				t.Fatalf("%s: %v", st.name, err)
+			}
+			if got := texts(doc); !slices.Equal(got, []string{st.want}) {
+				t.Errorf("%s: texts = %q, want %q", st.name, got, st.want)
+			}
+			continue
+		}
+		if !strings.Contains(string(body), st.want) {
+			t.Errorf("%s: body %s, want %s", st.name, body, st.want)
+		}
+	}
+}
+
+func TestServerReadOnly(t *testing.T) {
+	s := NewServer(ServerOptions{ReadOnly: true})
+	if err := s.Register("hello", textTemplate(t, "Hello {{ .Name }}")); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	tests := []struct {
+		method, path string
+		status       int
+	}{
+		{"PUT", "/templates/other", http.StatusMethodNotAllowed},
+		{"DELETE", "/templates/hello", http.StatusMethodNotAllowed},
+		{"POST", "/templates/hello/render", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if status, body, _ := request(t, srv, tt.method, tt.path, []byte(`{"Name":"Ann"}`)); status != tt.status {
+			t.Errorf("%s %s: status %d, want %d: %s", tt.method, tt.path, status, tt.status, body)
+		}
+	}
+}
+
+func TestServerTimeout(t *testing.T) {
+	s := NewServer(ServerOptions{RenderTimeout: 20 * time.Millisecond})
+	slow := textTemplate(t, "{{for i in Items}}", "{{ wait }}", "{{endfor}}")
+	slow.Funcs(map[string]interface{}{"wait": func() string {
+		time.Sleep(10 * time.Millisecond)
+		return ""
+	}})
+	if err := s.Register("slow", slow); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(s)
+	defer srv.Close()
+	status, body, _ := request(t, srv, "POST", "/templates/slow/render", []byte(`{"Items":[1,2,3,4,5,6,7,8,9,10]}`))
+	if status != http.StatusGatewayTimeout || !strings.Contains(string(body), "deadline exceeded") {
+		t.Errorf("status %d: %s, want a timeout", status, body)
+	}
+}
@@ -0,0 +1,74 @@
+package docxexp
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// valueFunc is the template function capturing the value of a {{set}} expression
+const valueFunc = "__value"
+
+var setNameRe = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// parseSetTag parses a {{set name = expr}} tag
+func (t *DocxTemplate) parseSetTag(text string) (string, string, bool) {
+	content, ok := t.delims.blockTag(text)
+	if !ok || !strings.HasPrefix(content, "set ") {
+		return "", "", false
+	}
+	name, expr, ok := strings.Cut(strings.TrimPrefix(content, "set "), "=")
+	name, expr = strings.TrimSpace(name), strings.TrimSpace(expr)
+	if !ok || !setNameRe.MatchString(name) || expr == "" {
+		return "", "", false
+	}
+	return name, expr, true
+}
+
+// setVar binds name to val for the rest of the innermost scope
+func (t *DocxTemplate) setVar(name string, val interface{}) {
+	t.vars[len(t.vars)-1][name] = val
+}
+
+// lookupVar returns the value bound to name by {{set}}, innermost scope first
+func (t *DocxTemplate) lookupVar(name string) (interface{}, bool) {
+	for i := len(t.vars) - 1; i >= 0; i-- {
+		if val, ok := t.vars[i][name]; ok {
+			return val, true
+		}
+	}
+	return nil, false
+}
+
+// varFuncs returns the {{set}} bindings in scope as template functions
+func (t *DocxTemplate) varFuncs() template.FuncMap {
+	funcs := make(template.FuncMap)
+	for _, frame := range t.vars {
+		for k, v := range frame {
+			val := v
+			funcs[k] = func() interface{} { return val }
+		}
+	}
+	return funcs
+}
+
+// evalPipeline evaluates expr as a text/template pipeline, such as
+// len .Items, and returns its value
+func (t *DocxTemplate) evalPipeline(expr string, data interface{}) (interface{}, error) {
+	var val interface{}
+	capture := template.FuncMap{valueFunc: func(v interface{}) string {
+		val = v
+		return ""
+	}}
+	text := t.delims.left + " " + valueFunc + " (" + expr + ") " + t.delims.right
+	tmpl, err := t.inlineTemplate(text, data, capture)
+	if err != nil {
+		return nil, &TemplateError{Err: err}
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, inlineError(err)
+	}
+	return val, nil
+}
@@ -0,0 +1,36 @@
+package docxexp
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSet(t *testing.T) {
+	data := map[string]interface{}{
+		"Name":     "Ann",
+		"Findings": []map[string]string{{"Title": "a"}, {"Title": "b"}},
+	}
+	tests := []struct {
+		name  string
+		lines []string
+		want  []string
+	}{
+		{"inline", []string{"{{set total = len .Findings}}", "{{ total }} findings"}, []string{"2 findings"}},
+		{"block tag", []string{"{{set shown = .Name}}", "{{if shown}}", "{{ shown }}", "{{endif}}"}, []string{"Ann"}},
+		{"loop", []string{"{{set total = len .Findings}}", "{{for f in .Findings}}", "{{ f.Title }}/{{ total }}", "{{endfor}}"}, []string{"a/2", "b/2"}},
+		{"shadows data", []string{"{{set Name = \"Bob\"}}", "{{ Name }} {{ .Name }}"}, []string{"Bob Ann"}},
+		{"loop scope", []string{"{{set x = \"out\"}}", "{{for f in .Findings}}", "{{set x = f.Title}}", "{{ x }}", "{{endfor}}", "{{ x }}"}, []string{"a", "b", "out"}},
+		{"pipeline", []string{"{{set n = .Name | printf \"%s!\"}}", "{{ n }}"}, []string{"Ann!"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := textTemplate(t, tt.lines...)
+			if err := tpl.Render(data); err != nil {
+				t.Fatal(err)
+			}
+			if got := texts(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("texts = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
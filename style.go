@@ -0,0 +1,143 @@
+package docxexp
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/fumiama/go-docx"
+)
+
+// styleOp is a formatting change requested from inside a template action
+type styleOp struct {
+	kind  string
+	value string
+}
+
+// styleFuncs returns the template functions that change cell and run formatting.
+// Each function returns a placeholder that is resolved by applyStyles once the
+// paragraph text has been rendered. An empty value leaves the formatting untouched.
+func (t *DocxTemplate) styleFuncs() map[string]interface{} {
+	mark := func(kind, value string) string {
+		id := fmt.Sprintf("__STYLE_%d__", t.marks)
+		t.marks++
+		t.styles[id] = styleOp{kind: kind, value: value}
+		return id
+	}
+	return map[string]interface{}{
+		"cellColor": func(fill string) (string, error) {
+			if t.cell == nil {
+				return "", fmt.Errorf("cellColor used outside of a table cell")
+			}
+			if err := checkColor("cellColor", fill); err != nil {
+				return "", err
+			}
+			return mark("cellColor", fill), nil
+		},
+		"textColor": func(color string) (string, error) {
+			if err := checkColor("textColor", color); err != nil {
+				return "", err
+			}
+			return mark("textColor", color), nil
+		},
+		"highlight": func(color string) (string, error) {
+			if color == "" {
+				return mark("highlight", ""), nil
+			}
+			named, ok := highlightColors[strings.ToLower(color)]
+			if !ok {
+				return "", fmt.Errorf("highlight color %q is not one of the named colors of w:highlight", color)
+			}
+			return mark("highlight", named), nil
+		},
+		"bold": func(on bool) string {
+			if !on {
+				return mark("bold", "off")
+			}
+			return mark("bold", "on")
+		},
+	}
+}
+
+var colorRe = regexp.MustCompile(`^[0-9A-Fa-f]{6}$|^auto$`)
+
+// checkColor returns an error if color, given to the function fn, is neither
+// empty, a hex RGB color nor auto
+func checkColor(fn, color string) error {
+	if color != "" && !colorRe.MatchString(color) {
+		return fmt.Errorf("%s color %q is not a hex color such as FF0000 or auto", fn, color)
+	}
+	return nil
+}
+
+// highlightColors are the colors w:highlight accepts, by their lower case
+var highlightColors = map[string]string{
+	"black": "black", "blue": "blue", "cyan": "cyan", "green": "green",
+	"magenta": "magenta", "red": "red", "yellow": "yellow", "white": "white",
+	"darkblue": "darkBlue", "darkcyan": "darkCyan", "darkgreen": "darkGreen",
+	"darkmagenta": "darkMagenta", "darkred": "darkRed", "darkyellow": "darkYellow",
+	"darkgray": "darkGray", "lightgray": "lightGray", "none": "none",
+}
+
+// applyStyles removes style placeholders from the runs of p and applies
+// the requested formatting to the run holding the placeholder, or to the
+// table cell currently being rendered.
+func (t *DocxTemplate) applyStyles(p *docx.Paragraph) error {
+	for _, child := range p.Children {
+		run, ok := child.(*docx.Run)
+		if !ok {
+			continue
+		}
+		for _, rc := range run.Children {
+			text, ok := rc.(*docx.Text)
+			if !ok || !strings.Contains(text.Text, "__STYLE_") {
+				continue
+			}
+			for id, op := range t.styles {
+				if !strings.Contains(text.Text, id) {
+					continue
+				}
+				text.Text = strings.Replace(text.Text, id, "", -1)
+				if op.value == "" {
+					continue
+				}
+				if err := t.applyStyle(run, op); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (t *DocxTemplate) applyStyle(run *docx.Run, op styleOp) error {
+	if op.kind == "cellColor" {
+		// Cell properties are shared with the template row after cloning
+		props := docx.WTableCellProperties{}
+		if t.cell.TableCellProperties != nil {
+			props = *t.cell.TableCellProperties
+		}
+		props.Shade = &docx.Shade{Val: "clear", Color: "auto", Fill: op.value}
+		t.cell.TableCellProperties = &props
+		return nil
+	}
+
+	// Run properties are shared with the template run after cloning
+	props := docx.RunProperties{}
+	if run.RunProperties != nil {
+		props = *run.RunProperties
+	}
+	switch op.kind {
+	case "textColor":
+		props.Color = &docx.Color{Val: op.value}
+	case "highlight":
+		props.Highlight = &docx.Highlight{Val: op.value}
+	case "bold":
+		props.Bold = nil
+		if op.value == "on" {
+			props.Bold = &docx.Bold{}
+		}
+	}
+	run.RunProperties = &props
+	return nil
+}
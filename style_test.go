@@ -0,0 +1,88 @@
+package docxexp
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+func TestFormatting(t *testing.T) {
+	tests := []struct {
+		name string
+		run  string
+		// text is the rendered run, written as *text* when it is bold, and
+		// props its other run properties, if any
+		text, props string
+		err         bool
+	}{
+		{"text color", `{{ textColor "FF0000" }}x`, "x", `<w:color w:val="FF0000">`, false},
+		{"text color auto", `{{ textColor "auto" }}x`, "x", `<w:color w:val="auto">`, false},
+		{"empty text color", `{{ textColor "" }}x`, "x", "", false},
+		{"named text color", `{{ textColor "red" }}x`, "", "", true},
+		{"short text color", `{{ textColor "F00" }}x`, "", "", true},
+		{"highlight", `{{ highlight "darkred" }}x`, "x", `<w:highlight w:val="darkRed">`, false},
+		{"unknown highlight", `{{ highlight "pink" }}x`, "", "", true},
+		{"bold", `{{ bold true }}x`, "*x*", "", false},
+		{"not bold", `*{{ bold false }}x*`, "x", "", false},
+		{"cell color outside of a cell", `{{ cellColor "FF0000" }}x`, "", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := runTemplate(t, tt.run)
+			err := tpl.Render(nil)
+			if tt.err {
+				if err == nil {
+					t.Fatal("rendered, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := runTexts(tpl); len(got) != 1 || got[0] != tt.text {
+				t.Errorf("runs = %q, want %q", got, tt.text)
+			}
+			document := savedPart(t, tpl, documentPart)
+			if tt.props != "" && !strings.Contains(document, tt.props) {
+				t.Errorf("document.xml lacks %s:\n%s", tt.props, document)
+			}
+			if tt.props == "" && strings.Contains(document, "<w:color") {
+				t.Errorf("document.xml has a color:\n%s", document)
+			}
+		})
+	}
+}
+
+func TestCellColor(t *testing.T) {
+	tests := []struct {
+		fill string
+		want string
+		err  bool
+	}{
+		{"00ff00", `w:fill="00ff00"`, false},
+		{"", "", false},
+		{"green", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.fill, func(t *testing.T) {
+			tpl := newTestTemplate(t, func(doc *docx.Docx) {
+				doc.AddTable(1, 1, 0, nil).TableRows[0].TableCells[0].AddParagraph().AddText(`{{ cellColor .Fill }}x`)
+			})
+			err := tpl.Render(map[string]interface{}{"Fill": tt.fill})
+			if tt.err {
+				if err == nil {
+					t.Fatal("rendered, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			document := savedPart(t, tpl, documentPart)
+			if tt.want == "" && strings.Contains(document, "w:fill=") || !strings.Contains(document, tt.want) {
+				t.Errorf("document.xml shading is wrong, want %q:\n%s", tt.want, document)
+			}
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package docxexp
+
+import (
+	"github.com/fumiama/go-docx"
+)
+
+// TableInjector injects a table built from a header row and rows of text
+type TableInjector struct {
+	Header []string
+	Rows   [][]string
+	// Width is the table width in twips, 0 for automatic
+	Width int64
+	// RepeatHeader repeats the header row at the top of every page
+	RepeatHeader bool
+	// CantSplit keeps each row on a single page
+	CantSplit bool
+}
+
+// Inject implements the Injector interface
+func (ti TableInjector) Inject(doc *docx.Docx, p *docx.Paragraph) ([]interface{}, error) {
+	rows := ti.Rows
+	if len(ti.Header) > 0 {
+		rows = append([][]string{ti.Header}, rows...)
+	}
+	cols := 0
+	for _, row := range rows {
+		if len(row) > cols {
+			cols = len(row)
+		}
+	}
+	if cols == 0 {
+		return []interface{}{}, nil
+	}
+
+	tbl := createTable(doc, len(rows), cols, ti.Width)
+	for i, row := range rows {
+		for j, text := range row {
+			tbl.TableRows[i].TableCells[j].AddParagraph().AddText(text)
+		}
+		if ti.CantSplit {
+			CantSplit(tbl.TableRows[i])
+		}
+	}
+	if len(ti.Header) > 0 && ti.RepeatHeader {
+		RepeatHeader(tbl.TableRows[0])
+	}
+	return []interface{}{tbl}, nil
+}
+
+func createTable(doc *docx.Docx, rows, cols int, width int64) *docx.Table {
+	tbl := doc.AddTable(rows, cols, width, nil)
+	// Remove from doc items to avoid duplication, as we will add it to the list manually
+	if len(doc.Document.Body.Items) > 0 {
+		doc.Document.Body.Items = doc.Document.Body.Items[:len(doc.Document.Body.Items)-1]
+	}
+	return tbl
+}
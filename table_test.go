@@ -0,0 +1,191 @@
+package docxexp
+
+import (
+	"bytes"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/fumiama/go-docx"
+)
+
+// bodyItems returns the text of each paragraph of the body of tpl and, for
+// each table, "table:" followed by the text of its rows
+func bodyItems(tpl *DocxTemplate) []string {
+	var out []string
+	for _, item := range tpl.doc.Document.Body.Items {
+		switch it := item.(type) {
+		case *docx.Paragraph:
+			out = append(out, tpl.getParagraphText(it))
+		case *docx.Table:
+			var rows []string
+			for _, row := range it.TableRows {
+				var cells []string
+				for _, cell := range row.TableCells {
+					for _, p := range cell.Paragraphs {
+						cells = append(cells, tpl.getParagraphText(p))
+					}
+				}
+				rows = append(rows, strings.Join(cells, "|"))
+			}
+			out = append(out, "table:"+strings.Join(rows, "/"))
+		}
+	}
+	return out
+}
+
+// captionTemplate returns a template holding a paragraph, caption, before a
+// table of the rows, each a single cell, and a paragraph after it. When
+// styled, the caption has the Caption style of the package.
+func captionTemplate(t *testing.T, caption string, styled bool, rows ...string) *DocxTemplate {
+	t.Helper()
+	data := packageOf(t, func(doc *docx.Docx) {
+		p := doc.AddParagraph()
+		p.AddText(caption)
+		if styled {
+			p.Style("Caption")
+		}
+		tbl := doc.AddTable(len(rows), 1, 0, nil)
+		for i, row := range rows {
+			tbl.TableRows[i].TableCells[0].AddParagraph().AddText(row)
+		}
+		doc.AddParagraph().AddText("after")
+	})
+	var buf bytes.Buffer
+	err := rewritePackage(data, &buf, map[string]func([]byte) ([]byte, error){
+		stylesPart: func(part []byte) ([]byte, error) {
+			return insertBefore(part, `<w:style w:type="paragraph" w:styleId="Caption"><w:name w:val="caption"/></w:style>`, "</w:styles>"), nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := New(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tpl
+}
+
+func TestTableRemoval(t *testing.T) {
+	items := []map[string]string{{"Name": "a"}, {"Name": "b"}}
+	loop := []string{"Name", "{{ range .Items }}{{ .Name }}"}
+	tests := []struct {
+		name    string
+		caption string
+		styled  bool
+		rows    []string
+		data    map[string]interface{}
+		opts    RenderOptions
+		want    []string
+	}{
+		{"tableif true", "Table 1", false, []string{"{{tableif .Show}}x"}, map[string]interface{}{"Show": true},
+			RenderOptions{}, []string{"Table 1", "table:x", "after"}},
+		{"tableif false", "Table 1", false, []string{"{{tableif .Show}}x"}, map[string]interface{}{"Show": false},
+			RenderOptions{}, []string{"Table 1", "after"}},
+		{"rows", "Table 1", false, loop, map[string]interface{}{"Items": items},
+			RenderOptions{RemoveEmptyTables: true}, []string{"Table 1", "table:Name/a/b", "after"}},
+		{"empty rows kept", "Table 1", false, loop, map[string]interface{}{"Items": nil},
+			RenderOptions{}, []string{"Table 1", "table:Name", "after"}},
+		{"empty rows removed", "Table 1", false, loop, map[string]interface{}{"Items": nil},
+			RenderOptions{RemoveEmptyTables: true}, []string{"Table 1", "after"}},
+		{"no rows left", "Table 1", false, []string{"{{ range .Items }}{{ .Name }}"}, map[string]interface{}{"Items": nil},
+			RenderOptions{}, []string{"Table 1", "after"}},
+		{"caption style", "Table 1", true, []string{"{{tableif .Show}}x"}, map[string]interface{}{"Show": false},
+			RenderOptions{RemoveEmptyTableCaption: true}, []string{"after"}},
+		{"caption tag", "{{caption}}Table 1", false, []string{"{{tableif .Show}}x"}, map[string]interface{}{"Show": false},
+			RenderOptions{RemoveEmptyTableCaption: true}, []string{"after"}},
+		{"caption kept", "{{caption}}Table 1", false, []string{"{{tableif .Show}}x"}, map[string]interface{}{"Show": false},
+			RenderOptions{}, []string{"Table 1", "after"}},
+		{"not a caption", "Table 1", false, []string{"{{tableif .Show}}x"}, map[string]interface{}{"Show": false},
+			RenderOptions{RemoveEmptyTableCaption: true}, []string{"Table 1", "after"}},
+		{"empty table text", "{{caption}}Table 1", false, loop, map[string]interface{}{"Items": nil},
+			RenderOptions{RemoveEmptyTables: true, RemoveEmptyTableCaption: true, EmptyTableText: "None"}, []string{"None", "after"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := captionTemplate(t, tt.caption, tt.styled, tt.rows...)
+			if err := tpl.RenderWithOptions(tt.data, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := bodyItems(tpl); !slices.Equal(got, tt.want) {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseRowTag(t *testing.T) {
+	tpl := &DocxTemplate{delims: defaultDelims}
+	tests := []struct {
+		text     string
+		tag, arg string
+		ok       bool
+	}{
+		{"{{range .Items}}{{ .Name }}", "{{range .Items}}", ".Items", true},
+		{"{{ range .Items }}", "{{ range .Items }}", ".Items", true},
+		{"{{- range .Items -}}", "{{- range .Items -}}", ".Items", true},
+		{"{{-range .Items-}}", "{{-range .Items-}}", ".Items", true},
+		{"  {{ range .Items }}x", "{{ range .Items }}", ".Items", true},
+		{"{{ range }}", "{{ range }}", "", true},
+		{"x {{ range .Items }}", "", "", false},
+		{"{{ ranges .Items }}", "", "", false},
+		{"{{ .range }}", "", "", false},
+		{"{{ range .Items", "", "", false},
+	}
+	for _, tt := range tests {
+		tag, arg, ok := tpl.parseRowTag(tt.text, "range")
+		if tag != tt.tag || arg != tt.arg || ok != tt.ok {
+			t.Errorf("parseRowTag(%q) = %q, %q, %v, want %q, %q, %v", tt.text, tag, arg, ok, tt.tag, tt.arg, tt.ok)
+		}
+	}
+}
+
+// rowsTemplate returns a template holding a table of rows, each a list of
+// cells whose lines are paragraphs
+func rowsTemplate(t *testing.T, rows ...[]string) *DocxTemplate {
+	t.Helper()
+	return newTestTemplate(t, func(doc *docx.Docx) {
+		tbl := doc.AddTable(len(rows), len(rows[0]), 0, nil)
+		for i, row := range rows {
+			for j, cell := range row {
+				for _, line := range strings.Split(cell, "\n") {
+					tbl.TableRows[i].TableCells[j].AddParagraph().AddText(line)
+				}
+			}
+		}
+	})
+}
+
+func TestRowTags(t *testing.T) {
+	items := []map[string]string{{"Name": "a"}, {"Name": "b"}}
+	tests := []struct {
+		name string
+		rows [][]string
+		show bool
+		want string
+	}{
+		{"range", [][]string{{"{{range .Items}}{{ .Name }}", "x"}}, false, "table:a|x/b|x"},
+		{"spaced range", [][]string{{"{{ range .Items }}{{ .Name }}", "x"}}, false, "table:a|x/b|x"},
+		{"trimmed range", [][]string{{"{{- range .Items -}}{{ .Name }}", "x"}}, false, "table:a|x/b|x"},
+		{"range in any cell", [][]string{{"{{ .Name }}", "x", "{{ range .Items }}{{ .Name }}!"}}, false, "table:a|x|a!/b|x|b!"},
+		{"range in a later paragraph", [][]string{{"{{ .Name }}\n{{ range .Items }}"}}, false, "table:a|/b|"},
+		{"inline range", [][]string{{"{{ range .Items }}{{ .Name }}{{ end }}"}}, false, "table:ab"},
+		{"row if", [][]string{{"{{if .Show}}"}, {"x"}, {"{{endif}}"}, {"y"}}, false, "table:y"},
+		{"row if shown", [][]string{{"{{ if .Show }}"}, {"x"}, {"{{ endif }}"}, {"y"}}, true, "table:x/y"},
+		// An {{if}} closed in its own cell is a paragraph block, not a row
+		{"if in a cell", [][]string{{"{{if .Show}}\nx\n{{endif}}"}, {"y"}}, false, "table:/y"},
+		{"if in a cell shown", [][]string{{"{{if .Show}}\nx\n{{endif}}"}, {"y"}}, true, "table:x/y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tpl := rowsTemplate(t, tt.rows...)
+			if err := tpl.Render(map[string]interface{}{"Items": items, "Show": tt.show, "Name": "n"}); err != nil {
+				t.Fatal(err)
+			}
+			if got := bodyItems(tpl); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("body = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}